@@ -0,0 +1,292 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// authentication schemes of --auth and targets' auth
+const (
+	authNone   = "none"
+	authBasic  = "basic"
+	authBearer = "bearer"
+	authOAuth2 = "oauth2"
+)
+
+const (
+	// oauth2 tokens are refreshed a tenth of their lifetime, at most this long, before they expire
+	oauth2ExpiryMargin = 30 * time.Second
+	// after a failed token fetch requests fail without fetching for this long, doubling
+	// with every failure up to oauth2MaxRetryBackoff
+	oauth2RetryBackoff    = time.Second
+	oauth2MaxRetryBackoff = 30 * time.Second
+	// token responses are read up to this size, large signed JWTs are longer than a logged body
+	maxTokenResponse = 1 << 20
+)
+
+type (
+	// authenticator sets the credentials of requests
+	authenticator interface {
+		// Apply sets the credentials of req, ctx bounds the time spent obtaining them
+		Apply(ctx context.Context, req *http.Request) error
+		// Invalidate is called when req was rejected with a 401
+		Invalidate(req *http.Request)
+	}
+	basicAuth struct {
+		username, password string
+	}
+	bearerAuth struct {
+		token string
+	}
+	// oauth2Auth fetches access tokens with the OAuth2 client credentials grant and
+	// caches them until shortly before they expire or are rejected. Failed fetches are
+	// cached too, so an unavailable token endpoint is not hit by every request.
+	oauth2Auth struct {
+		tokenURL     string
+		clientID     string
+		clientSecret string
+		scopes       string
+		client       *http.Client
+
+		mu       sync.Mutex
+		token    string
+		expires  time.Time
+		err      error     // of the last fetch
+		failures int       // consecutive failed fetches
+		retryAt  time.Time // when to fetch again after a failure
+	}
+	// authError is returned when the credentials of a request could not be obtained
+	authError struct {
+		err error
+	}
+)
+
+// parseAuth parses an auth spec, secrets are read from env:NAME or file:PATH:
+//
+//	basic:username=USER,password=SECRET
+//	bearer:token=SECRET
+//	oauth2:token-url=URL,client-id=ID,client-secret=SECRET[,scopes=SCOPE SCOPE...]
+//
+// An empty spec or none returns no authenticator.
+func parseAuth(spec string) (authenticator, error) {
+	kind, args, _ := strings.Cut(strings.TrimSpace(spec), ":")
+	switch kind {
+	case "", authNone:
+		return nil, nil
+	case authBasic:
+		opts, err := parseOptions(args, "username", "password")
+		if err != nil {
+			return nil, fmt.Errorf("invalid basic auth: %v", err)
+		}
+		a := &basicAuth{}
+		if a.username, err = readValue(opts["username"]); err != nil {
+			return nil, fmt.Errorf("invalid basic auth username: %v", err)
+		}
+		if a.password, err = readSecret(opts["password"]); err != nil {
+			return nil, fmt.Errorf("invalid basic auth password: %v", err)
+		}
+		return a, nil
+	case authBearer:
+		opts, err := parseOptions(args, "token")
+		if err != nil {
+			return nil, fmt.Errorf("invalid bearer auth: %v", err)
+		}
+		a := &bearerAuth{}
+		if a.token, err = readSecret(opts["token"]); err != nil {
+			return nil, fmt.Errorf("invalid bearer auth token: %v", err)
+		}
+		return a, nil
+	case authOAuth2:
+		opts, err := parseOptions(args, "token-url", "client-id", "client-secret")
+		if err != nil {
+			return nil, fmt.Errorf("invalid oauth2 auth: %v", err)
+		}
+		a := &oauth2Auth{tokenURL: opts["token-url"], scopes: opts["scopes"], client: &http.Client{Timeout: 10 * time.Second}}
+		if u, err := url.Parse(a.tokenURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid oauth2 auth token-url %q: expected an http(s) URL", a.tokenURL)
+		}
+		if a.clientID, err = readValue(opts["client-id"]); err != nil {
+			return nil, fmt.Errorf("invalid oauth2 auth client-id: %v", err)
+		}
+		if a.clientSecret, err = readSecret(opts["client-secret"]); err != nil {
+			return nil, fmt.Errorf("invalid oauth2 auth client-secret: %v", err)
+		}
+		return a, nil
+	default:
+		return nil, fmt.Errorf("unknown auth %q: must be one of none, basic, bearer or oauth2", kind)
+	}
+}
+
+// readSecret reads a secret from env:NAME or file:PATH, so it is not visible in
+// command lines, config files or target definitions
+func readSecret(ref string) (string, error) {
+	if !strings.HasPrefix(ref, "env:") && !strings.HasPrefix(ref, "file:") {
+		return "", fmt.Errorf("secrets must be read from env:NAME or file:PATH")
+	}
+	return readValue(ref)
+}
+
+// readValue reads env:NAME and file:PATH references, other values are used as is
+func readValue(ref string) (string, error) {
+	switch {
+	case strings.HasPrefix(ref, "env:"):
+		name := strings.TrimPrefix(ref, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok || v == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(ref, "file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(ref, "file:"))
+		if err != nil {
+			return "", err
+		}
+		// files usually end with a newline
+		v := strings.TrimSpace(string(b))
+		if v == "" {
+			return "", fmt.Errorf("%s is empty", strings.TrimPrefix(ref, "file:"))
+		}
+		return v, nil
+	case ref == "":
+		return "", fmt.Errorf("missing value")
+	}
+	return ref, nil
+}
+
+func (a *basicAuth) Apply(ctx context.Context, req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+func (a *basicAuth) Invalidate(req *http.Request) {}
+
+func (a *bearerAuth) Apply(ctx context.Context, req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+func (a *bearerAuth) Invalidate(req *http.Request) {}
+
+// Apply sets the cached access token, fetching a new one when it is missing or about to expire
+func (a *oauth2Auth) Apply(ctx context.Context, req *http.Request) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token == "" || (!a.expires.IsZero() && time.Now().After(a.expires)) {
+		if a.err != nil && time.Now().Before(a.retryAt) {
+			return &authError{err: a.err}
+		}
+		if err := a.fetch(ctx); err != nil {
+			if ctx.Err() == nil {
+				backoff := oauth2RetryBackoff << a.failures
+				if backoff > oauth2MaxRetryBackoff || backoff <= 0 {
+					backoff = oauth2MaxRetryBackoff
+				}
+				a.failures++
+				a.err, a.retryAt = err, time.Now().Add(backoff)
+			}
+			return &authError{err: err}
+		}
+		a.err, a.failures = nil, 0
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+	return nil
+}
+
+// Invalidate drops the token req was sent with, unless it was already replaced
+func (a *oauth2Auth) Invalidate(req *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if req.Header.Get("Authorization") == "Bearer "+a.token {
+		a.token = ""
+	}
+}
+
+// fetch gets an access token from the token URL with the client credentials grant
+func (a *oauth2Auth) fetch(ctx context.Context) error {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if a.scopes != "" {
+		form.Set("scope", a.scopes)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to fetch an oauth2 token: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTokenResponse+1))
+	if err != nil {
+		return fmt.Errorf("unable to fetch an oauth2 token: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if len(body) > maxLoggedBody {
+			body = body[:maxLoggedBody]
+		}
+		return fmt.Errorf("unable to fetch an oauth2 token: %v returned %d: %s", a.tokenURL, resp.StatusCode, body)
+	}
+	if len(body) > maxTokenResponse {
+		return fmt.Errorf("invalid oauth2 token response from %v: larger than %d bytes", a.tokenURL, maxTokenResponse)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return fmt.Errorf("invalid oauth2 token response from %v: %v", a.tokenURL, err)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("invalid oauth2 token response from %v: missing access_token", a.tokenURL)
+	}
+	a.token, a.expires = token.AccessToken, time.Time{}
+	if token.ExpiresIn > 0 {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		margin := lifetime / 10
+		if margin > oauth2ExpiryMargin {
+			margin = oauth2ExpiryMargin
+		}
+		a.expires = time.Now().Add(lifetime - margin)
+	}
+	return nil
+}
+
+func (e *authError) Error() string {
+	return "auth: " + e.err.Error()
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,246 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_parseAuth(t *testing.T) {
+	t.Setenv("TEST_AUTH_SECRET", "s3cret")
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		spec       string
+		wantHeader string
+		wantErr    bool
+	}{
+		{name: "none", spec: "none"},
+		{name: "empty", spec: ""},
+		{name: "basic", spec: "basic:username=alice,password=env:TEST_AUTH_SECRET", wantHeader: "Basic YWxpY2U6czNjcmV0"},
+		{name: "bearer from file", spec: "bearer:token=file:" + secretFile, wantHeader: "Bearer from-file"},
+		{name: "secret on the command line", spec: "bearer:token=s3cret", wantErr: true},
+		{name: "unset env", spec: "bearer:token=env:TEST_AUTH_UNSET", wantErr: true},
+		{name: "missing file", spec: "bearer:token=file:/nonexistent", wantErr: true},
+		{name: "missing password", spec: "basic:username=alice", wantErr: true},
+		{name: "oauth2", spec: "oauth2:token-url=https://idp/token,client-id=load,client-secret=env:TEST_AUTH_SECRET,scopes=read write"},
+		{name: "oauth2 token url", spec: "oauth2:token-url=idp/token,client-id=load,client-secret=env:TEST_AUTH_SECRET", wantErr: true},
+		{name: "unknown", spec: "digest:username=alice", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAuth(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantHeader == "" {
+				return
+			}
+			req, _ := http.NewRequest(http.MethodGet, "http://a/", nil)
+			if err := got.Apply(context.Background(), req); err != nil || req.Header.Get("Authorization") != tt.wantHeader {
+				t.Errorf("Apply() Authorization = %q, %v, want %q", req.Header.Get("Authorization"), err, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func Test_oauth2Auth(t *testing.T) {
+	t.Setenv("TEST_AUTH_SECRET", "s3cret")
+	var fetched, denied int32
+	expiresIn := 3600
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if id != "load" || secret != "s3cret" || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "read write" {
+			atomic.AddInt32(&denied, 1)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		n := atomic.AddInt32(&fetched, 1)
+		fmt.Fprintf(w, `{"access_token": "token-%d", "token_type": "Bearer", "expires_in": %d}`, n, expiresIn)
+	}))
+	defer idp.Close()
+	a, err := parseAuth("oauth2:token-url=" + idp.URL + ",client-id=load,client-secret=env:TEST_AUTH_SECRET,scopes=read write")
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply := func() string {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, "http://a/", nil)
+		if err := a.Apply(context.Background(), req); err != nil {
+			t.Fatalf("Apply() error = %v", err)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	if got := apply(); got != "Bearer token-1" {
+		t.Errorf("Apply() = %v, want the fetched token", got)
+	}
+	if got := apply(); got != "Bearer token-1" || fetched != 1 {
+		t.Errorf("Apply() = %v after %d fetches, want the cached token", got, fetched)
+	}
+
+	// a rejected request drops the token, unless a new one was fetched since
+	stale, _ := http.NewRequest(http.MethodGet, "http://a/", nil)
+	stale.Header.Set("Authorization", "Bearer token-0")
+	a.Invalidate(stale)
+	if got := apply(); got != "Bearer token-1" {
+		t.Errorf("Apply() after invalidating an older token = %v, want token-1", got)
+	}
+	rejected, _ := http.NewRequest(http.MethodGet, "http://a/", nil)
+	rejected.Header.Set("Authorization", "Bearer token-1")
+	a.Invalidate(rejected)
+	if got := apply(); got != "Bearer token-2" {
+		t.Errorf("Apply() after a 401 = %v, want a new token", got)
+	}
+
+	// tokens are refreshed before they expire
+	a.(*oauth2Auth).expires = time.Now().Add(-time.Second)
+	if got := apply(); got != "Bearer token-3" {
+		t.Errorf("Apply() after expiry = %v, want a new token", got)
+	}
+
+	a.(*oauth2Auth).clientSecret = "wrong"
+	a.(*oauth2Auth).token = ""
+	req, _ := http.NewRequest(http.MethodGet, "http://a/", nil)
+	if err := a.Apply(context.Background(), req); err == nil || errorClass(err) != "auth" {
+		t.Errorf("Apply() with a rejected client error = %v, want an auth error", err)
+	}
+
+	// failed fetches are not retried by every request
+	if err := a.Apply(context.Background(), req); err == nil || denied != 1 {
+		t.Errorf("Apply() after a failed fetch = %v after %d fetches, want the cached error", err, denied)
+	}
+	if backoff := time.Until(a.(*oauth2Auth).retryAt); backoff <= 0 || backoff > oauth2RetryBackoff {
+		t.Errorf("oauth2Auth.retryAt in %v, want within %v", backoff, oauth2RetryBackoff)
+	}
+	a.(*oauth2Auth).retryAt = time.Now()
+	a.Apply(context.Background(), req)
+	if backoff := time.Until(a.(*oauth2Auth).retryAt); denied != 2 || backoff <= oauth2RetryBackoff || backoff > 2*oauth2RetryBackoff {
+		t.Errorf("oauth2Auth.retryAt in %v after %d fetches, want a doubled backoff after 2", backoff, denied)
+	}
+
+	// the fetch is bounded by the context, which is not a failure of the token endpoint
+	a.(*oauth2Auth).clientSecret = "s3cret"
+	a.(*oauth2Auth).retryAt = time.Now()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := a.Apply(ctx, req); err == nil || fetched != 3 {
+		t.Errorf("Apply() with a canceled context = %v after %d fetches, want an error without fetching", err, fetched)
+	}
+	if got := apply(); got != "Bearer token-4" {
+		t.Errorf("Apply() after the backoff = %v, want a new token", got)
+	}
+}
+
+func Test_oauth2Auth_largeResponse(t *testing.T) {
+	t.Setenv("TEST_AUTH_SECRET", "s3cret")
+	token := strings.Repeat("x", 8<<10)
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		padding := ""
+		if r.URL.Query().Has("oversized") {
+			padding = strings.Repeat(" ", maxTokenResponse)
+		}
+		fmt.Fprintf(w, `{"access_token": %q, "token_type": "Bearer", "padding": %q}`, token, padding)
+	}))
+	defer idp.Close()
+	tests := []struct {
+		name     string
+		tokenURL string
+		wantErr  string
+	}{
+		{name: "8KiB token", tokenURL: idp.URL},
+		{name: "oversized response", tokenURL: idp.URL + "?oversized", wantErr: "larger than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := parseAuth("oauth2:token-url=" + tt.tokenURL + ",client-id=load,client-secret=env:TEST_AUTH_SECRET")
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest(http.MethodGet, "http://a/", nil)
+			err = a.Apply(context.Background(), req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Apply() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || req.Header.Get("Authorization") != "Bearer "+token {
+				t.Errorf("Apply() error = %v, want the %d byte token", err, len(token))
+			}
+		})
+	}
+}
+
+func TestRLHTTPClient_Do_auth(t *testing.T) {
+	t.Setenv("TEST_AUTH_SECRET", "s3cret")
+	var authorization atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization.Store(r.Header.Get("Authorization"))
+	}))
+	defer srv.Close()
+	defaults := &Target{Auth: "bearer:token=env:TEST_AUTH_SECRET"}
+	defaults.auth, _ = parseAuth(defaults.Auth)
+	inherited, _ := parseTarget(srv.URL)
+	none, _ := parseTarget(`{"url": "` + srv.URL + `", "auth": "none"}`)
+
+	tests := []struct {
+		name   string
+		target *Target
+		want   string
+	}{
+		{name: "inherited", target: inherited, want: "Bearer s3cret"},
+		{name: "disabled", target: none, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.target.inherit(defaults)
+			if err := tt.target.compile(); err != nil {
+				t.Fatal(err)
+			}
+			c := newClient(nil)
+			c.stats = newStats(0)
+			c.Do(tt.target, nil, 0, log.New(io.Discard, "", 0))
+			if got := authorization.Load().(string); got != tt.want {
+				t.Errorf("Authorization = %q, want %q", got, tt.want)
+			}
+			if summary := c.stats.Summaries()[0]; !strings.Contains(summary.String(), "codes=200:1") {
+				t.Errorf("Summary = %v, want a 200", summary)
+			}
+		})
+	}
+}
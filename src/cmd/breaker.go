@@ -0,0 +1,219 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// errCircuitOpen is the error of requests not sent because their target's circuit breaker is open
+var errCircuitOpen = errors.New("circuit breaker open")
+
+type (
+	// breakerConfig configures the circuit breakers of the worker's targets. A breaker opens
+	// after failures consecutive failures, or when at least failureRate % of the last window
+	// requests failed, rejects requests for openFor, then lets probes requests through and
+	// closes if they all succeed.
+	breakerConfig struct {
+		failures    int
+		failureRate float64
+		window      int
+		openFor     time.Duration
+		probes      int
+		on          outcomeSet
+	}
+	// breakers holds a circuit breaker per target
+	breakers struct {
+		config *breakerConfig
+		logger *log.Logger
+		mu     sync.Mutex
+		byName map[string]*breaker
+		order  []string
+	}
+	breaker struct {
+		name   string
+		config *breakerConfig
+		logger *log.Logger
+
+		mu          sync.Mutex
+		state       string
+		since       time.Time
+		consecutive int
+		outcomes    []bool // ring of the last window outcomes, true if failed
+		next        int
+		probes      int
+		succeeded   int
+	}
+	// breakerState is the JSON view of a breaker on the worker's health port
+	breakerState struct {
+		Target              string    `json:"target"`
+		State               string    `json:"state"`
+		Since               time.Time `json:"since"`
+		ConsecutiveFailures int       `json:"consecutive_failures"`
+		FailureRate         float64   `json:"failure_rate"`
+	}
+)
+
+func newBreakers(config *breakerConfig, logger *log.Logger) *breakers {
+	return &breakers{config: config, logger: logger, byName: map[string]*breaker{}}
+}
+
+// For returns the breaker of a target
+func (bs *breakers) For(target *Target) *breaker {
+	name := target.Name()
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	b, ok := bs.byName[name]
+	if !ok {
+		b = &breaker{name: name, config: bs.config, logger: bs.logger, state: breakerClosed, since: time.Now()}
+		bs.byName[name] = b
+		bs.order = append(bs.order, name)
+	}
+	return b
+}
+
+// States returns the state of every breaker
+func (bs *breakers) States() []breakerState {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	states := make([]breakerState, 0, len(bs.order))
+	for _, name := range bs.order {
+		states = append(states, bs.byName[name].State())
+	}
+	return states
+}
+
+// Allow reports whether a request may be sent, moving an open breaker to half-open once it was open long enough
+func (b *breaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.since) < b.config.openFor {
+			return false
+		}
+		b.transition(breakerHalfOpen)
+		fallthrough
+	case breakerHalfOpen:
+		if b.probes >= b.config.probes {
+			return false
+		}
+		b.probes++
+		return true
+	default:
+		return true
+	}
+}
+
+// Record adds the outcome of a request allowed by the breaker
+func (b *breaker) Record(resp *http.Response, err error) {
+	failed := b.config.on.Match(resp, err)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerHalfOpen:
+		if failed {
+			b.transition(breakerOpen)
+			return
+		}
+		if b.succeeded++; b.succeeded >= b.config.probes {
+			b.transition(breakerClosed)
+		}
+	case breakerClosed:
+		if failed {
+			b.consecutive++
+		} else {
+			b.consecutive = 0
+		}
+		if b.config.window > 0 {
+			if len(b.outcomes) < b.config.window {
+				b.outcomes = append(b.outcomes, failed)
+			} else {
+				b.outcomes[b.next] = failed
+				b.next = (b.next + 1) % b.config.window
+			}
+		}
+		if (b.config.failures > 0 && b.consecutive >= b.config.failures) ||
+			(b.config.failureRate > 0 && len(b.outcomes) == b.config.window && b.failureRate() >= b.config.failureRate) {
+			b.transition(breakerOpen)
+		}
+	}
+}
+
+// State returns a snapshot of the breaker
+func (b *breaker) State() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return breakerState{
+		Target:              b.name,
+		State:               b.state,
+		Since:               b.since,
+		ConsecutiveFailures: b.consecutive,
+		FailureRate:         b.failureRate(),
+	}
+}
+
+// failure rate of the window in %
+func (b *breaker) failureRate() float64 {
+	if len(b.outcomes) == 0 {
+		return 0
+	}
+	failed := 0
+	for _, f := range b.outcomes {
+		if f {
+			failed++
+		}
+	}
+	return float64(failed) / float64(len(b.outcomes)) * 100
+}
+
+// transition changes the state and resets the counters of the new state, callers hold mu
+func (b *breaker) transition(state string) {
+	b.logger.Printf("Circuit breaker for %v: %v -> %v", b.name, b.state, state)
+	b.state, b.since = state, time.Now()
+	b.probes, b.succeeded = 0, 0
+	if state == breakerClosed {
+		b.consecutive, b.outcomes, b.next = 0, nil, 0
+	}
+}
+
+// breakerStates serves the state of the worker's circuit breakers as JSON
+func breakerStates(bs *breakers) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		states := []breakerState{}
+		if bs != nil {
+			states = bs.States()
+		}
+		writeJSON(w, start, http.StatusOK, states)
+	})
+}
@@ -0,0 +1,117 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func newTestBreaker(t *testing.T, config breakerConfig) *breaker {
+	t.Helper()
+	on, err := parseOutcomes("500-599,connection_refused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.on = on
+	target, _ := parseTarget("http://a/")
+	return newBreakers(&config, log.New(io.Discard, "", 0)).For(target)
+}
+
+func Test_breaker(t *testing.T) {
+	ok := &http.Response{StatusCode: 200}
+	fail := &http.Response{StatusCode: 503}
+	tests := []struct {
+		name      string
+		config    breakerConfig
+		outcomes  []*http.Response
+		wantState string
+	}{
+		{name: "consecutive failures", config: breakerConfig{failures: 3, probes: 1}, outcomes: []*http.Response{fail, fail, fail}, wantState: breakerOpen},
+		{name: "interrupted failures", config: breakerConfig{failures: 3, probes: 1}, outcomes: []*http.Response{fail, fail, ok, fail, fail}, wantState: breakerClosed},
+		{name: "failure rate", config: breakerConfig{failureRate: 50, window: 4, probes: 1}, outcomes: []*http.Response{fail, ok, fail, ok}, wantState: breakerOpen},
+		{name: "failure rate below threshold", config: breakerConfig{failureRate: 50, window: 4, probes: 1}, outcomes: []*http.Response{fail, ok, ok, ok, fail}, wantState: breakerClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBreaker(t, tt.config)
+			for _, resp := range tt.outcomes {
+				if !b.Allow() {
+					t.Fatalf("breaker.Allow() = false while %v", b.state)
+				}
+				b.Record(resp, nil)
+			}
+			if got := b.State().State; got != tt.wantState {
+				t.Errorf("breaker state = %v, want %v", got, tt.wantState)
+			}
+		})
+	}
+}
+
+func Test_breaker_halfOpen(t *testing.T) {
+	b := newTestBreaker(t, breakerConfig{failures: 1, openFor: 20 * time.Millisecond, probes: 2})
+	b.Record(&http.Response{StatusCode: 500}, nil)
+	if b.Allow() {
+		t.Fatalf("breaker.Allow() = true, want requests rejected while open")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !b.Allow() || !b.Allow() || b.Allow() {
+		t.Fatalf("half-open breaker.Allow() should let exactly 2 probes through")
+	}
+	b.Record(&http.Response{StatusCode: 200}, nil)
+	if got := b.State().State; got != breakerHalfOpen {
+		t.Errorf("breaker state = %v after 1 of 2 probes succeeded, want %v", got, breakerHalfOpen)
+	}
+	b.Record(&http.Response{StatusCode: 200}, nil)
+	if got := b.State().State; got != breakerClosed {
+		t.Errorf("breaker state = %v after every probe succeeded, want %v", got, breakerClosed)
+	}
+
+	b.Record(&http.Response{StatusCode: 500}, nil)
+	time.Sleep(30 * time.Millisecond)
+	b.Allow()
+	b.Record(nil, syscall.ECONNREFUSED)
+	if got := b.State().State; got != breakerOpen {
+		t.Errorf("breaker state = %v after a probe failed, want %v", got, breakerOpen)
+	}
+}
+
+func Test_breakerStates(t *testing.T) {
+	on, _ := parseOutcomes("500")
+	bs := newBreakers(&breakerConfig{failures: 1, openFor: time.Minute, probes: 1, on: on}, log.New(io.Discard, "", 0))
+	target, _ := parseTarget("http://a/")
+	bs.For(target).Record(&http.Response{StatusCode: 500}, nil)
+	rec := httptest.NewRecorder()
+	breakerStates(bs).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/breakers", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `"target":"http://a/","state":"open"`) {
+		t.Errorf("breakerStates() = %v, want http://a/ open", body)
+	}
+	if rec.Header().Get("X-Response-Code") != "200" || rec.Header().Get("X-Request-Duration") == "" {
+		t.Errorf("breakerStates() headers = %v, want the response code and duration", rec.Header())
+	}
+}
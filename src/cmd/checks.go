@@ -0,0 +1,249 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// bodies are read up to this size for checks and failure logs
+	maxCheckBody = 1 << 20 // 1 MB
+	// failed responses are logged with at most this much of their body
+	maxLoggedBody = 4 << 10 // 4 KB
+)
+
+type (
+	// Checks are assertions on the responses of a target, a response failing any of them
+	// is counted as failed rather than as an error
+	Checks struct {
+		Status     string            `json:"status"`
+		Body       string            `json:"body"`
+		BodyRegex  string            `json:"body-regex"`
+		JSON       map[string]string `json:"json"`
+		Header     []string          `json:"header"`
+		MaxLatency string            `json:"max-latency"`
+
+		statuses   []statusRange
+		bodyRegex  *regexp.Regexp
+		json       []jsonCheck
+		maxLatency time.Duration
+	}
+	statusRange struct {
+		min, max int
+	}
+	jsonCheck struct {
+		path string
+		want string
+	}
+)
+
+// inherit fills in the checks the target does not set itself from defaults
+func (c *Checks) inherit(defaults Checks) {
+	if c.Status == "" {
+		c.Status = defaults.Status
+	}
+	if c.Body == "" {
+		c.Body = defaults.Body
+	}
+	if c.BodyRegex == "" {
+		c.BodyRegex = defaults.BodyRegex
+	}
+	if len(c.JSON) == 0 {
+		c.JSON = defaults.JSON
+	}
+	if len(c.Header) == 0 {
+		c.Header = defaults.Header
+	}
+	if c.MaxLatency == "" {
+		c.MaxLatency = defaults.MaxLatency
+	}
+}
+
+// compile parses the checks
+func (c *Checks) compile() error {
+	var err error
+	if c.statuses, err = parseStatusRanges(c.Status); err != nil {
+		return err
+	}
+	if c.BodyRegex != "" {
+		if c.bodyRegex, err = regexp.Compile(c.BodyRegex); err != nil {
+			return fmt.Errorf("invalid body regex %q: %v", c.BodyRegex, err)
+		}
+	}
+	c.json = make([]jsonCheck, 0, len(c.JSON))
+	for path, want := range c.JSON {
+		c.json = append(c.json, jsonCheck{path: path, want: canonicalJSON(want)})
+	}
+	sort.Slice(c.json, func(i, j int) bool { return c.json[i].path < c.json[j].path })
+	if c.MaxLatency != "" {
+		if c.maxLatency, err = time.ParseDuration(c.MaxLatency); err != nil {
+			return fmt.Errorf("invalid max latency %q: %v", c.MaxLatency, err)
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether there is anything to check
+func (c *Checks) Enabled() bool {
+	return len(c.statuses) > 0 || c.Body != "" || c.bodyRegex != nil || len(c.json) > 0 ||
+		len(c.Header) > 0 || c.maxLatency > 0
+}
+
+// Run returns the checks a response fails, ex status, body, json:data.id, header:ETag or latency
+func (c *Checks) Run(resp *http.Response, body []byte, latency time.Duration) []string {
+	var failed []string
+	if len(c.statuses) > 0 && !statusIn(resp.StatusCode, c.statuses) {
+		failed = append(failed, "status")
+	}
+	if c.Body != "" && !bytes.Contains(body, []byte(c.Body)) {
+		failed = append(failed, "body")
+	}
+	if c.bodyRegex != nil && !c.bodyRegex.Match(body) {
+		failed = append(failed, "body_regex")
+	}
+	if len(c.json) > 0 {
+		var doc interface{}
+		decodeErr := json.Unmarshal(body, &doc)
+		for _, check := range c.json {
+			v, ok := jsonPath(doc, check.path)
+			if decodeErr != nil || !ok || marshalCanonical(v) != check.want {
+				failed = append(failed, "json:"+check.path)
+			}
+		}
+	}
+	for _, name := range c.Header {
+		if resp.Header.Get(name) == "" {
+			failed = append(failed, "header:"+http.CanonicalHeaderKey(name))
+		}
+	}
+	if c.maxLatency > 0 && latency > c.maxLatency {
+		failed = append(failed, "latency")
+	}
+	return failed
+}
+
+// checkKind groups failed checks for reporting, ex json:data.id is counted as json
+func checkKind(check string) string {
+	kind, _, _ := strings.Cut(check, ":")
+	return kind
+}
+
+// parseStatusRanges parses a comma separated list of status codes and ranges, ex 200-299,304
+func parseStatusRanges(spec string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(field, "-")
+		min, err := strconv.Atoi(strings.TrimSpace(lo))
+		max := min
+		if err == nil && isRange {
+			max, err = strconv.Atoi(strings.TrimSpace(hi))
+		}
+		if err != nil || min < 100 || max > 599 || min > max {
+			return nil, fmt.Errorf("invalid status %q: want a code or range between 100 and 599, ex 200-299", field)
+		}
+		ranges = append(ranges, statusRange{min: min, max: max})
+	}
+	return ranges, nil
+}
+
+func statusIn(code int, ranges []statusRange) bool {
+	for _, r := range ranges {
+		if code >= r.min && code <= r.max {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonPath returns the value at a dot separated path of object keys and array indexes, ex data.items.0.id
+func jsonPath(doc interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return doc, true
+	}
+	v := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// canonicalJSON normalizes an expected value, which is either JSON, ex 42, true or "42", or a plain string
+func canonicalJSON(want string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(want), &v); err != nil {
+		return marshalCanonical(want)
+	}
+	return marshalCanonical(v)
+}
+
+func marshalCanonical(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// readBody reads and closes a response body, up to maxCheckBody
+func readBody(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
+	return io.ReadAll(io.LimitReader(resp.Body, maxCheckBody))
+}
+
+// parseJSONChecks parses `PATH=VALUE` flags
+func parseJSONChecks(specs []string) (map[string]string, error) {
+	checks := map[string]string{}
+	for _, spec := range specs {
+		path, want, ok := strings.Cut(spec, "=")
+		if !ok || strings.TrimSpace(path) == "" {
+			return nil, fmt.Errorf("invalid JSON check %q: expected PATH=VALUE", spec)
+		}
+		checks[strings.TrimSpace(path)] = want
+	}
+	return checks, nil
+}
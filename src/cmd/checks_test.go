@@ -0,0 +1,109 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_parseStatusRanges(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    []statusRange
+		wantErr bool
+	}{
+		{name: "empty", spec: "", want: nil},
+		{name: "codes and ranges", spec: "200-299, 304", want: []statusRange{{200, 299}, {304, 304}}},
+		{name: "inverted range", spec: "299-200", wantErr: true},
+		{name: "out of range", spec: "600", wantErr: true},
+		{name: "not a number", spec: "2xx", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseStatusRanges(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseStatusRanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStatusRanges() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_jsonPath(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"data": {"items": [{"id": 42}, {"id": 43}]}, "ok": true}`), &doc)
+	tests := []struct {
+		path   string
+		want   interface{}
+		wantOk bool
+	}{
+		{path: "ok", want: true, wantOk: true},
+		{path: "$.data.items.1.id", want: float64(43), wantOk: true},
+		{path: "data.items.2.id", wantOk: false},
+		{path: "data.missing", wantOk: false},
+		{path: "ok.nested", wantOk: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := jsonPath(doc, tt.path)
+			if ok != tt.wantOk || (ok && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("jsonPath() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestChecks_Run(t *testing.T) {
+	resp := &http.Response{StatusCode: 200, Header: http.Header{"Etag": []string{"abc"}}}
+	body := []byte(`{"data": {"id": 42, "name": "widget"}}`)
+	tests := []struct {
+		name   string
+		checks Checks
+		want   []string
+	}{
+		{name: "no checks", checks: Checks{}, want: nil},
+		{name: "passing", checks: Checks{Status: "200-299", Body: "widget", BodyRegex: `"id": \d+`,
+			JSON: map[string]string{"data.id": "42", "data.name": "widget"}, Header: []string{"ETag"}, MaxLatency: "1s"}, want: nil},
+		{name: "status", checks: Checks{Status: "201,204"}, want: []string{"status"}},
+		{name: "body", checks: Checks{Body: "gadget", BodyRegex: "^<html>"}, want: []string{"body", "body_regex"}},
+		{name: "json", checks: Checks{JSON: map[string]string{"data.id": `"42"`, "data.price": "1"}}, want: []string{"json:data.id", "json:data.price"}},
+		{name: "header", checks: Checks{Header: []string{"x-request-id"}}, want: []string{"header:X-Request-Id"}},
+		{name: "latency", checks: Checks{MaxLatency: "100ms"}, want: []string{"latency"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.checks.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.checks.Run(resp, body, 200*time.Millisecond); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Checks.Run() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
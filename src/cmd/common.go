@@ -22,17 +22,23 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
+	"github.com/cam3ron2/example-app/src/faultserver"
 	timerate "golang.org/x/time/rate"
 	httptrace "gopkg.in/DataDog/dd-trace-go.v1/contrib/net/http"
 )
@@ -42,12 +48,36 @@ type (
 	RLHTTPClient struct {
 		client      *http.Client
 		Ratelimiter *timerate.Limiter
+		stats       *Stats
+		results     *resultsFile
+		retry       *retryPolicy
+		breakers    *breakers
+
+		// every reconnectEvery requests are sent with Connection: close, see connOptions
+		reconnectEvery int64
+		sent           int64
+
+		// responses failing checks are logged with their body, up to logFailures of them
+		logFailures    int64
+		loggedFailures int64
 	}
 	Request struct {
-		R  *http.Request
-		r  *http.Response
-		e  error
-		id string
+		R         *http.Request
+		r         *http.Response
+		e         error
+		id        string
+		target    *Target
+		start     time.Time
+		intended  time.Time // when the request was scheduled, start is later if the worker fell behind
+		latency   time.Duration
+		body      []byte
+		failed    []string
+		attempts  int
+		connected bool
+		reused    bool
+		jar       http.CookieJar          // cookies of the journey the request is a step of
+		ctx       context.Context         // of the worker, cancels waits between attempts
+		extract   func(*Request) []string // sets a journey's variables, returning the failed extractions
 	}
 	Server struct {
 		name    string
@@ -55,12 +85,31 @@ type (
 		logger  *log.Logger
 		router  *http.ServeMux
 		datadog bool
+		limits  Limits
+		healthy int32
+		stop    chan struct{}
+		stopped int32
+	}
+	Limits struct {
+		ReadTimeout       time.Duration
+		ReadHeaderTimeout time.Duration
+		WriteTimeout      time.Duration
+		IdleTimeout       time.Duration
+		MaxHeaderBytes    int
+		MaxBodyBytes      int64
+	}
+	// lockedSource is a rand.Source safe for concurrent use by requests in flight
+	lockedSource struct {
+		mu  sync.Mutex
+		src rand.Source
 	}
 	App interface {
 		Start()
 		Serve()
 		NewLogger() *log.Logger
 		NewRouter() *http.ServeMux
+		Healthy() bool
+		SetHealthy(healthy bool)
 	}
 )
 
@@ -70,22 +119,51 @@ const (
 	letterIdxBits     = 6                    // 6 bits to represent a letter index
 	letterIdxMask     = 1<<letterIdxBits - 1 // All 1-bits, as many as letterIdxBits
 	letterIdxMax      = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
+
+	defaultReadTimeout       = 5 * time.Second
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultWriteTimeout      = 10 * time.Second
+	defaultIdleTimeout       = 15 * time.Second
+	defaultMaxBodyBytes      = 1 << 20 // 1 MB
 )
 
 var (
-	healthy int32
-	src     = rand.NewSource(time.Now().UnixNano())
+	src = &lockedSource{src: rand.NewSource(time.Now().UnixNano())}
 )
 
-func (s Server) NewLogger() *log.Logger {
+func (s *Server) NewLogger() *log.Logger {
 	return log.New(os.Stdout, "["+s.name+"] ", log.LstdFlags)
 }
 
-func (s Server) NewRouter() *http.ServeMux {
+func (s *Server) NewRouter() *http.ServeMux {
 	return http.NewServeMux()
 }
 
-func (s Server) Serve() {
+// Healthy reports whether the server is ready to handle requests
+func (s *Server) Healthy() bool {
+	return atomic.LoadInt32(&s.healthy) == 1
+}
+
+// SetHealthy marks the server as ready or not, /healthz fails while it is unhealthy
+func (s *Server) SetHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&s.healthy, 1)
+	} else {
+		atomic.StoreInt32(&s.healthy, 0)
+	}
+}
+
+// Stop shuts the server down as if it was interrupted, it requires a server created with a stop channel
+func (s *Server) Stop() {
+	if s.stop == nil {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&s.stopped, 0, 1) {
+		close(s.stop)
+	}
+}
+
+func (s *Server) Serve() {
 	var server = &http.Server{}
 	nextRequestID := func() string {
 		return strconv.FormatInt(time.Now().UnixNano(), 10)
@@ -93,24 +171,15 @@ func (s Server) Serve() {
 
 	// instantiate server
 	listenAddr := ":" + strconv.Itoa(s.port)
-	if s.datadog {
-		server = &http.Server{
-			Addr:         listenAddr,
-			Handler:      tracing(nextRequestID)(logResp(s.logger)(s.router)),
-			ErrorLog:     s.logger,
-			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 10 * time.Second,
-			IdleTimeout:  15 * time.Second,
-		}
-	} else {
-		server = &http.Server{
-			Addr:         listenAddr,
-			Handler:      tracing(nextRequestID)(logResp(s.logger)(s.router)),
-			ErrorLog:     s.logger,
-			ReadTimeout:  5 * time.Second,
-			WriteTimeout: 10 * time.Second,
-			IdleTimeout:  15 * time.Second,
-		}
+	server = &http.Server{
+		Addr:              listenAddr,
+		Handler:           tracing(nextRequestID)(logResp(s.logger)(maxBytes(s.limits.MaxBodyBytes)(s.router))),
+		ErrorLog:          s.logger,
+		ReadTimeout:       s.limits.ReadTimeout,
+		ReadHeaderTimeout: s.limits.ReadHeaderTimeout,
+		WriteTimeout:      s.limits.WriteTimeout,
+		IdleTimeout:       s.limits.IdleTimeout,
+		MaxHeaderBytes:    s.limits.MaxHeaderBytes,
 	}
 
 	done := make(chan bool)
@@ -118,9 +187,12 @@ func (s Server) Serve() {
 	signal.Notify(quit, os.Interrupt)
 
 	go func() {
-		<-quit
+		select {
+		case <-quit:
+		case <-s.stop:
+		}
 		s.logger.Println("Server is shutting down...")
-		atomic.StoreInt32(&healthy, 0)
+		s.SetHealthy(false)
 
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
@@ -133,7 +205,7 @@ func (s Server) Serve() {
 	}()
 
 	s.logger.Printf("Server is ready to handle requests at %v", listenAddr)
-	atomic.StoreInt32(&healthy, 1)
+	s.SetHealthy(true)
 	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		s.logger.Fatalf("Unable to start server on %s: %v\n", listenAddr, err)
 	}
@@ -143,31 +215,11 @@ func (s Server) Serve() {
 }
 
 func index(delay int, percentage int) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		if r.URL.Path != "/" {
-			w.Header().Set("X-Response-Code", "404")
-			w.Header().Set("X-Request-Duration", time.Since(start).String())
-			w.WriteHeader(http.StatusNotFound)
-			w.Write([]byte("404 - Not Found"))
-			return
-		}
-		if rand.Intn(100) < percentage {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-			w.Header().Set("X-Response-Code", "500")
-			w.Header().Set("X-Request-Duration", time.Since(start).String())
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte("500 - Internal Server Error"))
-		} else {
-			time.Sleep(time.Duration(delay) * time.Millisecond)
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.Header().Set("X-Response-Code", "200")
-			w.Header().Set("X-Request-Duration", time.Since(start).String())
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("200 - OK"))
-		}
-	})
+	faults := faultserver.Faults{
+		Delay:       time.Duration(delay) * time.Millisecond,
+		FailPercent: percentage,
+	}
+	return faultserver.Index(func() faultserver.Faults { return faults })
 }
 
 func notFound(start time.Time) http.Handler {
@@ -179,26 +231,24 @@ func notFound(start time.Time) http.Handler {
 	})
 }
 
-func healthz(percentage int) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		if rand.Intn(100) > percentage {
-			if atomic.LoadInt32(&healthy) == 1 {
-				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-				w.Header().Set("X-Content-Type-Options", "nosniff")
-				w.Header().Set("X-Response-Code", "204")
-				w.Header().Set("X-Request-Duration", time.Since(start).String())
-				w.WriteHeader(http.StatusNoContent)
-				return
-			}
-		} else {
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.Header().Set("X-Response-Code", "503")
-			w.Header().Set("X-Request-Duration", time.Since(start).String())
-			w.WriteHeader(http.StatusServiceUnavailable)
-		}
-	})
+// writeStatus sets the response code and duration headers logged by logResp and writes the status
+func writeStatus(w http.ResponseWriter, start time.Time, status int) {
+	w.Header().Set("X-Response-Code", strconv.Itoa(status))
+	w.Header().Set("X-Request-Duration", time.Since(start).String())
+	w.WriteHeader(status)
+}
+
+// httpError replies with a plain text error like http.Error, setting the headers logged by logResp
+func httpError(w http.ResponseWriter, start time.Time, error string, status int) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	writeStatus(w, start, status)
+	fmt.Fprintln(w, error)
+}
+
+func healthz(percentage int, healthy func() bool) http.Handler {
+	faults := faultserver.Faults{HealthFailPercent: percentage}
+	return faultserver.Healthz(func() faultserver.Faults { return faults }, healthy)
 }
 
 func logResp(logger *log.Logger) func(http.Handler) http.Handler {
@@ -216,6 +266,44 @@ func logResp(logger *log.Logger) func(http.Handler) http.Handler {
 	}
 }
 
+// rejects request bodies larger than limit, a limit <= 0 disables the check. Bodies of
+// unknown length, ex chunked, are read up to the limit before the request is handled.
+func maxBytes(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if limit <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			reject := func(status int) {
+				w.Header().Set("X-Response-Code", strconv.Itoa(status))
+				w.Header().Set("X-Request-Duration", time.Since(start).String())
+				w.WriteHeader(status)
+				w.Write([]byte(strconv.Itoa(status) + " - " + http.StatusText(status)))
+			}
+			if r.ContentLength > limit {
+				reject(http.StatusRequestEntityTooLarge)
+				return
+			}
+			if r.ContentLength < 0 {
+				body, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+				r.Body.Close()
+				switch {
+				case err != nil:
+					reject(http.StatusBadRequest)
+					return
+				case int64(len(body)) > limit:
+					reject(http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(body))
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func tracing(nextRequestID func() string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -230,6 +318,18 @@ func tracing(nextRequestID func() string) func(http.Handler) http.Handler {
 	}
 }
 
+// returns the limits Serve used before they were configurable
+func defaultLimits() Limits {
+	return Limits{
+		ReadTimeout:       defaultReadTimeout,
+		ReadHeaderTimeout: defaultReadHeaderTimeout,
+		WriteTimeout:      defaultWriteTimeout,
+		IdleTimeout:       defaultIdleTimeout,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+		MaxBodyBytes:      defaultMaxBodyBytes,
+	}
+}
+
 // creates a single rate-limited client
 func newClient(rateLimit *timerate.Limiter) *RLHTTPClient {
 	return &RLHTTPClient{
@@ -240,25 +340,124 @@ func newClient(rateLimit *timerate.Limiter) *RLHTTPClient {
 	}
 }
 
-func (c *RLHTTPClient) Do(url string, percentage int, logger *log.Logger) {
-	var req = &Request{
-		id: randString(6),
+// Do sends a request to target now
+func (c *RLHTTPClient) Do(target *Target, data map[string]interface{}, percentage int, logger *log.Logger) {
+	c.DoAt(context.Background(), time.Now(), target, data, percentage, logger)
+}
+
+// DoAt sends a request intended to be sent at intended, the time it waited past it
+// counts towards its response time, correcting the stats for coordinated omission.
+// Retries of the request stop once ctx is done.
+func (c *RLHTTPClient) DoAt(ctx context.Context, intended time.Time, target *Target, data map[string]interface{}, percentage int, logger *log.Logger) {
+	c.do(&Request{target: target, intended: intended, ctx: ctx}, data, percentage, logger)
+}
+
+// do builds and sends a request to req.target and records its outcome
+func (c *RLHTTPClient) do(req *Request, data map[string]interface{}, percentage int, logger *log.Logger) {
+	target := req.target
+	req.id, req.start = randString(6), time.Now()
+	req.R, req.e = target.newRequest(data)
+	if req.e != nil {
+		req.e = &requestError{err: req.e}
+	} else if target.auth != nil {
+		req.e = target.auth.Apply(req.context(), req.R)
+	}
+	if req.e == nil {
+		if rand.Intn(100) < percentage {
+			req.R.URL.Path = strings.TrimSuffix(req.R.URL.Path, "/") + "/" + req.id + "/"
+			req.R.URL.RawPath = ""
+		}
+		if req.R.Header.Get("X-Request-Id") == "" {
+			req.R.Header.Set("X-Request-Id", req.id)
+		}
+		if c.reconnectEvery > 0 && atomic.AddInt64(&c.sent, 1)%c.reconnectEvery == 0 {
+			req.R.Close = true
+		}
+		traceConn(req)
+		c.sendThroughBreaker(req, logger)
+		if req.e == nil && (target.Check.Enabled() || req.extract != nil) {
+			if req.body, req.e = readBody(req.r); req.e == nil {
+				req.failed = target.Check.Run(req.r, req.body, req.latency)
+				if req.extract != nil {
+					req.failed = append(req.failed, req.extract(req)...)
+				}
+			}
+		}
+	}
+	if c.stats != nil {
+		c.stats.Record(req)
 	}
-	if rand.Intn(100) < percentage {
-		url += req.id + "/"
+	if c.results != nil {
+		c.results.Record(req)
 	}
-	req.R, _ = http.NewRequest("GET", url, nil)
-	req.r, req.e = c.client.Do(req.R)
 	req.logReq(logger)
+	if len(req.failed) > 0 && atomic.AddInt64(&c.loggedFailures, 1) <= c.logFailures {
+		req.logFailure(logger)
+	}
+}
+
+// responseTime is the latency plus the time the request waited past its intended send time
+func (req *Request) responseTime() time.Duration {
+	if late := req.start.Sub(req.intended); !req.intended.IsZero() && late > 0 {
+		return req.latency + late
+	}
+	return req.latency
+}
+
+// sendThroughBreaker sends a request unless the circuit breaker of its target is open
+func (c *RLHTTPClient) sendThroughBreaker(req *Request, logger *log.Logger) {
+	var b *breaker
+	if c.breakers != nil {
+		if b = c.breakers.For(req.target); !b.Allow() {
+			req.e = fmt.Errorf("%w for %v", errCircuitOpen, req.target.Name())
+			return
+		}
+	}
+	req.start = time.Now()
+	c.send(req, logger)
+	req.latency = time.Since(req.start)
+	if b != nil {
+		b.Record(req.r, req.e)
+	}
 }
 
 func (req Request) logReq(logger *log.Logger) {
+	attempts := ""
+	if req.attempts > 1 {
+		attempts = " attempts=" + strconv.Itoa(req.attempts)
+	}
 	if req.e != nil {
-		logger.Println(req.e.Error())
+		logger.Println(req.e.Error() + attempts)
+		return
+	}
+	defer drainBody(req.r)
+	if len(req.failed) > 0 {
+		logger.Printf("[%v][%v] -> [%s] %s failed=%s%s", req.r.Request.Method, req.r.Request.URL, strconv.Itoa(req.r.StatusCode), req.r.Header.Get("X-Request-Duration"), strings.Join(req.failed, ","), attempts)
 		return
 	}
-	logger.Printf("[%v][%v] -> [%s] %s", req.r.Request.Method, req.r.Request.URL, strconv.Itoa(req.r.StatusCode), req.r.Header.Get("X-Request-Duration"))
-	defer req.r.Body.Close()
+	logger.Printf("[%v][%v] -> [%s] %s%s", req.r.Request.Method, req.r.Request.URL, strconv.Itoa(req.r.StatusCode), req.r.Header.Get("X-Request-Duration"), attempts)
+}
+
+// logs a response that failed its checks with its body
+func (req Request) logFailure(logger *log.Logger) {
+	body := req.body
+	if len(body) > maxLoggedBody {
+		body = body[:maxLoggedBody]
+	}
+	logger.Printf("[%v][%v] -> [%d] failed checks %s, response body:\n%s",
+		req.r.Request.Method, req.r.Request.URL, req.r.StatusCode, strings.Join(req.failed, ","), body)
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
 }
 
 func randString(n int) string {
@@ -22,9 +22,12 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"io"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -123,23 +126,41 @@ func Test_notFound(t *testing.T) {
 func Test_healthz(t *testing.T) {
 	type args struct {
 		percentage int
+		healthy    bool
 	}
 	tests := []struct {
 		name string
 		args args
-		want http.Handler
+		want int
 	}{
-		// TODO: Add test cases.
+		{name: "healthy", args: args{percentage: 0, healthy: true}, want: http.StatusNoContent},
+		{name: "unhealthy", args: args{percentage: 0, healthy: false}, want: http.StatusServiceUnavailable},
+		{name: "always fail", args: args{percentage: 100, healthy: true}, want: http.StatusServiceUnavailable},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := healthz(tt.args.percentage); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("healthz() = %v, want %v", got, tt.want)
+			w := httptest.NewRecorder()
+			healthz(tt.args.percentage, func() bool { return tt.args.healthy }).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+			if w.Code != tt.want {
+				t.Errorf("healthz() status = %v, want %v", w.Code, tt.want)
 			}
 		})
 	}
 }
 
+func TestServer_SetHealthy(t *testing.T) {
+	a, b := &Server{name: "a"}, &Server{name: "b"}
+	a.SetHealthy(true)
+	if !a.Healthy() || b.Healthy() {
+		t.Errorf("Server.SetHealthy() a = %v, b = %v, want true, false", a.Healthy(), b.Healthy())
+	}
+	b.SetHealthy(true)
+	a.SetHealthy(false)
+	if a.Healthy() || !b.Healthy() {
+		t.Errorf("Server.SetHealthy() a = %v, b = %v, want false, true", a.Healthy(), b.Healthy())
+	}
+}
+
 func Test_logResp(t *testing.T) {
 	type args struct {
 		logger *log.Logger
@@ -202,7 +223,8 @@ func Test_newClient(t *testing.T) {
 
 func TestRLHTTPClient_Do(t *testing.T) {
 	type args struct {
-		url        string
+		target     *Target
+		data       map[string]interface{}
 		percentage int
 		logger     *log.Logger
 	}
@@ -215,7 +237,7 @@ func TestRLHTTPClient_Do(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			tt.c.Do(tt.args.url, tt.args.percentage, tt.args.logger)
+			tt.c.Do(tt.args.target, tt.args.data, tt.args.percentage, tt.args.logger)
 		})
 	}
 }
@@ -257,3 +279,49 @@ func Test_randString(t *testing.T) {
 		})
 	}
 }
+
+func Test_maxBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		limit   int64
+		body    string
+		chunked bool
+		want    int
+	}{
+		{name: "disabled", limit: 0, body: "0123456789", want: http.StatusOK},
+		{name: "under limit", limit: 16, body: "0123456789", want: http.StatusOK},
+		{name: "over limit", limit: 4, body: "0123456789", want: http.StatusRequestEntityTooLarge},
+		{name: "chunked under limit", limit: 10, body: "0123456789", chunked: true, want: http.StatusOK},
+		{name: "chunked over limit", limit: 4, body: "0123456789", chunked: true, want: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				got = string(body)
+				w.WriteHeader(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			if tt.chunked {
+				// the length of chunked bodies is unknown until they are read
+				r.ContentLength, r.TransferEncoding = -1, []string{"chunked"}
+			}
+			maxBytes(tt.limit)(next).ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("maxBytes() status = %v, want %v", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && got != tt.body {
+				t.Errorf("maxBytes() body = %q, want %q", got, tt.body)
+			}
+		})
+	}
+}
+
+func Test_defaultLimits(t *testing.T) {
+	got := defaultLimits()
+	if got.ReadTimeout != 5*time.Second || got.WriteTimeout != 10*time.Second || got.IdleTimeout != 15*time.Second {
+		t.Errorf("defaultLimits() = %+v, want the previous hard-coded timeouts", got)
+	}
+}
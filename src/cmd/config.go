@@ -0,0 +1,286 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	toml "github.com/pelletier/go-toml/v2"
+	"github.com/spf13/cast"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// flagCheck validates a parsed flag set, see validateFlags
+type flagCheck func(fs *pflag.FlagSet) error
+
+const envPrefix = "EXAMPLE_APP"
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the application configuration",
+}
+
+// configPrintCmd represents the config print command
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "Prints the effective configuration after merging defaults, config file, env vars and flags",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		format, _ := cmd.Flags().GetString("output")
+		settings := viper.AllSettings()
+		delete(settings, "config")
+		for _, c := range cmd.Root().Commands() {
+			if section, ok := settings[c.Name()].(map[string]interface{}); ok {
+				configTargets(c.LocalFlags(), section)
+			}
+		}
+		out, err := marshalConfig(settings, format)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(cmd.OutOrStdout(), string(out))
+		return nil
+	},
+}
+
+// initConfig reads the config file and env vars and applies them to every
+// flag not set on the command line, so flags > env > config file > defaults
+func initConfig(cmd *cobra.Command, args []string) error {
+	root := cmd.Root()
+	v := viper.GetViper()
+	v.SetEnvPrefix(envPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+	v.AutomaticEnv()
+
+	bindConfig(v, "", root.PersistentFlags())
+	for _, c := range root.Commands() {
+		bindConfig(v, c.Name(), c.LocalFlags())
+	}
+
+	if file := v.GetString("config"); file != "" {
+		v.SetConfigFile(file)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("unable to read config file %s: %v", file, err)
+		}
+	}
+
+	if err := applyConfig(v, "", root.PersistentFlags()); err != nil {
+		return err
+	}
+	for _, c := range root.Commands() {
+		if err := applyConfig(v, c.Name(), c.LocalFlags()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// config keys are namespaced by subcommand, ex `worker.rate`
+func configKey(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "." + name
+}
+
+func bindConfig(v *viper.Viper, prefix string, fs *pflag.FlagSet) {
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Name == "help" {
+			return
+		}
+		v.BindPFlag(configKey(prefix, f.Name), f)
+	})
+}
+
+// copies values found in env vars or the config file onto unchanged flags
+func applyConfig(v *viper.Viper, prefix string, fs *pflag.FlagSet) error {
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		key := configKey(prefix, f.Name)
+		if err != nil || f.Changed || f.Name == "help" || !v.IsSet(key) {
+			return
+		}
+		switch val := v.Get(key).(type) {
+		case []interface{}:
+			slice, ok := f.Value.(pflag.SliceValue)
+			if !ok {
+				err = fmt.Errorf("invalid value for %s: expected a single %s, got a list", key, f.Value.Type())
+				return
+			}
+			err = slice.Replace(configStrings(val))
+			f.Changed = true
+		default:
+			err = fs.Set(f.Name, cast.ToString(val))
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid value for %s: %v", key, err)
+		}
+	})
+	return err
+}
+
+// converts a config list to flag values, objects are passed on as JSON
+func configStrings(list []interface{}) []string {
+	values := make([]string, 0, len(list))
+	for _, item := range list {
+		switch item := item.(type) {
+		case map[string]interface{}:
+			b, _ := json.Marshal(item)
+			values = append(values, string(b))
+		default:
+			values = append(values, cast.ToString(item))
+		}
+	}
+	return values
+}
+
+// configTargets replaces the targets flags in a section of settings with the list of
+// objects accepted in config files, leaving out flags without targets
+func configTargets(fs *pflag.FlagSet, section map[string]interface{}) {
+	fs.VisitAll(func(f *pflag.Flag) {
+		v, ok := f.Value.(*targetsValue)
+		if !ok {
+			return
+		}
+		if len(v.targets) == 0 {
+			delete(section, f.Name)
+		} else {
+			section[f.Name] = v.configList()
+		}
+	})
+}
+
+func marshalConfig(settings map[string]interface{}, format string) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case "yaml", "yml":
+		return yaml.Marshal(settings)
+	case "json":
+		out, err := json.MarshalIndent(settings, "", "  ")
+		return append(out, '\n'), err
+	case "toml":
+		return toml.Marshal(settings)
+	default:
+		return nil, fmt.Errorf("unsupported output format %q, must be one of yaml, json or toml", format)
+	}
+}
+
+// validateFlags returns a PreRunE that rejects invalid flag values at startup
+func validateFlags(checks ...flagCheck) func(*cobra.Command, []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		for _, check := range checks {
+			if err := check(cmd.Flags()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// checks that integer flags fall within [min, max]
+func checkRange(min int64, max int64, names ...string) flagCheck {
+	return func(fs *pflag.FlagSet) error {
+		for _, name := range names {
+			n, err := strconv.ParseInt(fs.Lookup(name).Value.String(), 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid value for --%s: %v", name, err)
+			}
+			if n < min || n > max {
+				return fmt.Errorf("invalid value %d for --%s: must be between %d and %d", n, name, min, max)
+			}
+		}
+		return nil
+	}
+}
+
+func checkPercent(names ...string) flagCheck {
+	return checkRange(0, 100, names...)
+}
+
+func checkPort(names ...string) flagCheck {
+	return checkRange(1, 65535, names...)
+}
+
+func checkNonNegative(names ...string) flagCheck {
+	return checkRange(0, math.MaxInt64, names...)
+}
+
+func checkDuration(names ...string) flagCheck {
+	return func(fs *pflag.FlagSet) error {
+		for _, name := range names {
+			d, err := fs.GetDuration(name)
+			if err != nil {
+				return err
+			}
+			if d < 0 {
+				return fmt.Errorf("invalid value %v for --%s: must not be negative", d, name)
+			}
+		}
+		return nil
+	}
+}
+
+// checks that a string flag is one of the allowed values
+func checkOneOf(name string, allowed ...string) flagCheck {
+	return func(fs *pflag.FlagSet) error {
+		value, err := fs.GetString(name)
+		if err != nil {
+			return err
+		}
+		for _, a := range allowed {
+			if value == a {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid value %q for --%s: must be one of %s", value, name, strings.Join(allowed, ", "))
+	}
+}
+
+// checks that at most one of the flags is set
+func checkExclusive(names ...string) flagCheck {
+	return func(fs *pflag.FlagSet) error {
+		var set []string
+		for _, name := range names {
+			if fs.Changed(name) {
+				set = append(set, "--"+name)
+			}
+		}
+		if len(set) > 1 {
+			return fmt.Errorf("only one of %s can be set", strings.Join(set, ", "))
+		}
+		return nil
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configPrintCmd)
+
+	// Define flags
+	configPrintCmd.Flags().StringP("output", "o", "yaml", "output format, one of yaml, json or toml")
+}
@@ -0,0 +1,194 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+func newTestFlags() *pflag.FlagSet {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.Int("port", 8080, "")
+	fs.Int("rate", 1, "")
+	fs.StringSlice("header", nil, "")
+	fs.Var(&targetsValue{}, "target", "")
+	return fs
+}
+
+func Test_applyConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		env     map[string]string
+		args    []string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{name: "default", key: "port", want: "8080"},
+		{name: "config file", config: "server:\n  port: 9000\n", key: "port", want: "9000"},
+		{name: "env over config", config: "server:\n  port: 9000\n", env: map[string]string{"EXAMPLE_APP_SERVER_PORT": "9100"}, key: "port", want: "9100"},
+		{name: "flag over env", env: map[string]string{"EXAMPLE_APP_SERVER_PORT": "9100"}, args: []string{"--port", "9200"}, key: "port", want: "9200"},
+		{name: "list from config", config: "server:\n  header: [a, b]\n", key: "header", want: "[a,b]"},
+		{name: "list from env", env: map[string]string{"EXAMPLE_APP_SERVER_HEADER": "a,b"}, key: "header", want: "[a,b]"},
+		{name: "objects from config", config: "server:\n  target:\n    - url: http://a/\n      weight: 2\n    - http://b/\n", key: "target", want: "[http://a/ 2,http://b/ 1]"},
+		{name: "invalid value", config: "server:\n  rate: abc\n", key: "rate", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fs := newTestFlags()
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			v := viper.New()
+			v.SetEnvPrefix(envPrefix)
+			v.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
+			v.AutomaticEnv()
+			v.SetConfigType("yaml")
+			if err := v.ReadConfig(strings.NewReader(tt.config)); err != nil {
+				t.Fatal(err)
+			}
+			bindConfig(v, "server", fs)
+			err := applyConfig(v, "server", fs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := fs.Lookup(tt.key).Value.String(); got != tt.want {
+				t.Errorf("applyConfig() %s = %v, want %v", tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		check   flagCheck
+		wantErr bool
+	}{
+		{name: "valid port", args: []string{"--port", "80"}, check: checkPort("port")},
+		{name: "port too large", args: []string{"--port", "70000"}, check: checkPort("port"), wantErr: true},
+		{name: "valid percent", args: []string{"--rate", "100"}, check: checkPercent("rate")},
+		{name: "percent too large", args: []string{"--rate", "150"}, check: checkPercent("rate"), wantErr: true},
+		{name: "negative", args: []string{"--rate", "-1"}, check: checkNonNegative("rate"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := newTestFlags()
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.check(fs); (err != nil) != tt.wantErr {
+				t.Errorf("check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_marshalConfig(t *testing.T) {
+	settings := map[string]interface{}{"server": map[string]interface{}{"port": 8080}}
+	tests := []struct {
+		name    string
+		format  string
+		want    string
+		wantErr bool
+	}{
+		{name: "yaml", format: "yaml", want: "port: 8080"},
+		{name: "json", format: "json", want: `"port": 8080`},
+		{name: "toml", format: "toml", want: "port = 8080"},
+		{name: "unknown", format: "ini", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := marshalConfig(settings, tt.format)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("marshalConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !strings.Contains(string(got), tt.want) {
+				t.Errorf("marshalConfig() = %s, want it to contain %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_configTargets(t *testing.T) {
+	config := `server:
+  target:
+    - url: http://a:1/
+      method: POST
+      header: {X-Key: abc}
+      check: {status: "200-299", json: {a.b: "1"}}
+      auth: bearer:token=env:TOKEN
+    - http://b/ 3
+`
+	load := func(t *testing.T, format, config string) (*pflag.FlagSet, map[string]interface{}) {
+		t.Helper()
+		fs, other := newTestFlags(), newTestFlags()
+		v := viper.New()
+		v.SetConfigType(format)
+		if err := v.ReadConfig(strings.NewReader(config)); err != nil {
+			t.Fatal(err)
+		}
+		bindConfig(v, "server", fs)
+		bindConfig(v, "client", other)
+		if err := applyConfig(v, "server", fs); err != nil {
+			t.Fatalf("applyConfig() error = %v", err)
+		}
+		settings := v.AllSettings()
+		configTargets(fs, settings["server"].(map[string]interface{}))
+		configTargets(other, settings["client"].(map[string]interface{}))
+		return fs, settings
+	}
+	targetsJSON := func(fs *pflag.FlagSet) string {
+		b, _ := json.Marshal(getTargets(fs, "target"))
+		return string(b)
+	}
+
+	want, settings := load(t, "yaml", config)
+	if _, ok := settings["client"].(map[string]interface{})["target"]; ok {
+		t.Errorf("configTargets() kept an empty target key")
+	}
+	for _, format := range []string{"yaml", "json", "toml"} {
+		t.Run(format, func(t *testing.T) {
+			out, err := marshalConfig(settings, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := load(t, format, string(out))
+			if targetsJSON(got) != targetsJSON(want) {
+				t.Errorf("targets after printing and loading the config = %v, want %v\n%s", targetsJSON(got), targetsJSON(want), out)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+const (
+	ipAny = "any"
+	ipV4  = "ipv4"
+	ipV6  = "ipv6"
+)
+
+type (
+	// connOptions configure how the worker's client manages connections
+	connOptions struct {
+		disableKeepAlives bool
+		maxIdlePerHost    int
+		maxPerHost        int
+		dnsRefresh        time.Duration
+		preferIP          string
+	}
+	// resolver dials the addresses of a host in turn, preferring an IP version, and caches
+	// them for ttl, 0 resolving the host for every new connection
+	resolver struct {
+		ttl      time.Duration
+		preferIP string
+		dialer   *net.Dialer
+		lookup   func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+		mu    sync.Mutex
+		hosts map[string]*resolved
+	}
+	resolved struct {
+		addrs   []net.IP
+		expires time.Time
+		next    int
+	}
+)
+
+// newTransport returns a transport configured by opts, based on http.DefaultTransport
+func newTransport(opts connOptions) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = opts.disableKeepAlives
+	transport.MaxIdleConnsPerHost = opts.maxIdlePerHost
+	transport.MaxConnsPerHost = opts.maxPerHost
+	if opts.dnsRefresh > 0 || opts.preferIP != ipAny {
+		r := &resolver{
+			ttl:      opts.dnsRefresh,
+			preferIP: opts.preferIP,
+			dialer:   &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+			lookup:   net.DefaultResolver.LookupIPAddr,
+			hosts:    map[string]*resolved{},
+		}
+		transport.DialContext = r.DialContext
+	}
+	return transport
+}
+
+// closeIdleEvery closes the idle connections of transport every interval, so that hosts are
+// resolved again for the connections that replace them
+func closeIdleEvery(ctx context.Context, transport *http.Transport, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			transport.CloseIdleConnections()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// DialContext dials the addresses of the host of addr in turn until one connects
+func (r *resolver) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return r.dialer.DialContext(ctx, network, addr)
+	}
+	ips, err := r.resolve(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	for _, ip := range ips {
+		if conn, err = r.dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port)); err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// resolve returns the addresses of host, preferred IP version first, rotated on every call
+func (r *resolver) resolve(ctx context.Context, host string) ([]net.IP, error) {
+	r.mu.Lock()
+	entry, ok := r.hosts[host]
+	r.mu.Unlock()
+	if !ok || !time.Now().Before(entry.expires) {
+		addrs, err := r.lookup(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+		entry = &resolved{expires: time.Now().Add(r.ttl)}
+		for _, addr := range addrs {
+			entry.addrs = append(entry.addrs, addr.IP)
+		}
+		if len(entry.addrs) == 0 {
+			return nil, &net.DNSError{Err: "no addresses", Name: host, IsNotFound: true}
+		}
+		r.mu.Lock()
+		if cached, ok := r.hosts[host]; ok {
+			entry.next = cached.next
+		}
+		r.hosts[host] = entry
+		r.mu.Unlock()
+	}
+
+	r.mu.Lock()
+	start := entry.next
+	entry.next++
+	r.mu.Unlock()
+	var preferred, others []net.IP
+	for _, ip := range rotate(entry.addrs, start) {
+		is4 := ip.To4() != nil
+		if (r.preferIP == ipV4 && !is4) || (r.preferIP == ipV6 && is4) {
+			others = append(others, ip)
+		} else {
+			preferred = append(preferred, ip)
+		}
+	}
+	return append(preferred, others...), nil
+}
+
+func rotate(ips []net.IP, n int) []net.IP {
+	n %= len(ips)
+	return append(append([]net.IP{}, ips[n:]...), ips[:n]...)
+}
+
+// traceConn records whether the request is sent over a new or a reused connection
+func traceConn(req *Request) {
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			req.connected, req.reused = true, info.Reused
+		},
+	}
+	req.R = req.R.WithContext(httptrace.WithClientTrace(req.R.Context(), trace))
+}
+
+// drainBody reads what is left of a response body, up to maxDrainBody, and closes it so that
+// its connection can be reused
+func drainBody(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxDrainBody))
+	resp.Body.Close()
+}
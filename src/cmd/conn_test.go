@@ -0,0 +1,130 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	timerate "golang.org/x/time/rate"
+)
+
+func Test_resolver_resolve(t *testing.T) {
+	addrs := []net.IPAddr{{IP: net.ParseIP("10.0.0.1")}, {IP: net.ParseIP("fd00::1")}, {IP: net.ParseIP("10.0.0.2")}}
+	tests := []struct {
+		name     string
+		preferIP string
+		want     [][]string
+	}{
+		{name: "any", preferIP: ipAny, want: [][]string{{"10.0.0.1", "fd00::1", "10.0.0.2"}, {"fd00::1", "10.0.0.2", "10.0.0.1"}}},
+		{name: "ipv4", preferIP: ipV4, want: [][]string{{"10.0.0.1", "10.0.0.2", "fd00::1"}, {"10.0.0.2", "10.0.0.1", "fd00::1"}}},
+		{name: "ipv6", preferIP: ipV6, want: [][]string{{"fd00::1", "10.0.0.1", "10.0.0.2"}, {"fd00::1", "10.0.0.2", "10.0.0.1"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookups := 0
+			r := &resolver{
+				ttl:      time.Minute,
+				preferIP: tt.preferIP,
+				lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+					lookups++
+					return addrs, nil
+				},
+				hosts: map[string]*resolved{},
+			}
+			for _, want := range tt.want {
+				ips, err := r.resolve(context.Background(), "example.com")
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := make([]string, 0, len(ips))
+				for _, ip := range ips {
+					got = append(got, ip.String())
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("resolver.resolve() = %v, want %v", got, want)
+				}
+			}
+			if lookups != 1 {
+				t.Errorf("resolver looked up the host %v times within its ttl, want 1", lookups)
+			}
+		})
+	}
+}
+
+func Test_resolver_resolve_error(t *testing.T) {
+	r := &resolver{
+		lookup: func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		},
+		hosts: map[string]*resolved{},
+	}
+	var dnsErr *net.DNSError
+	if _, err := r.resolve(context.Background(), "example.invalid"); !errors.As(err, &dnsErr) {
+		t.Errorf("resolver.resolve() error = %v, want a DNS error", err)
+	}
+}
+
+func TestRLHTTPClient_Do_connections(t *testing.T) {
+	tests := []struct {
+		name           string
+		opts           connOptions
+		reconnectEvery int64
+		wantNew        int64
+	}{
+		{name: "keep-alive", opts: connOptions{maxIdlePerHost: 2, preferIP: ipAny}, wantNew: 1},
+		{name: "reconnect every 2", opts: connOptions{maxIdlePerHost: 2, preferIP: ipAny}, reconnectEvery: 2, wantNew: 3},
+		{name: "keep-alives disabled", opts: connOptions{disableKeepAlives: true, preferIP: ipAny}, wantNew: 6},
+		{name: "resolver", opts: connOptions{maxIdlePerHost: 2, dnsRefresh: time.Minute, preferIP: ipV4}, wantNew: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, "OK")
+			}))
+			defer srv.Close()
+			// a host name rather than the server's IP, for the resolver to resolve
+			target, _ := parseTarget(strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/")
+			target.compile()
+			client := newClient(timerate.NewLimiter(timerate.Inf, 1))
+			client.client.Transport = newTransport(tt.opts)
+			client.reconnectEvery = tt.reconnectEvery
+			client.stats = newStats(0)
+			for i := 0; i < 6; i++ {
+				client.Do(target, nil, 0, log.New(io.Discard, "", 0))
+			}
+			summary := client.stats.Summaries()[0]
+			if summary.NewConns != tt.wantNew || summary.ReusedConns != 6-tt.wantNew {
+				t.Errorf("new-conns = %v, reused-conns = %v, want %v new", summary.NewConns, summary.ReusedConns, tt.wantNew)
+			}
+		})
+	}
+}
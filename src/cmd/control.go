@@ -0,0 +1,274 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// control serves the live stats of a worker and steers it while it runs
+	control struct {
+		pacer    *rateController
+		picker   *targetPicker
+		stats    *Stats
+		defaults *Target
+		// profiled is true when a load profile sets the rate
+		profiled bool
+		users    *virtualUsers // set when the worker runs --vus virtual users instead of a rate
+		source   string        // the flag supplying the requests when it ignores the rate and targets, like --replay
+		writable bool          // the worker can be steered, set with --control
+		token    string        // bearer token required to steer the worker, if set
+		logger   *log.Logger
+	}
+	liveStats struct {
+		Rate          float64         `json:"rate"`
+		Users         int             `json:"vus,omitempty"`
+		Paused        bool            `json:"paused"`
+		WarmingUp     bool            `json:"warming_up"`
+		InFlight      int64           `json:"in_flight"`
+		Dropped       int64           `json:"dropped"`
+		Delayed       int64           `json:"delayed"`
+		RetriesDenied int64           `json:"retries_denied"`
+		Targets       []summaryRecord `json:"targets"`
+	}
+	rateRequest struct {
+		Rate *float64 `json:"rate"`
+	}
+)
+
+// sensitiveHeaders of targets are not served by the control API
+var sensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key", "X-Auth-Token"}
+
+// statsHandler responds with the current rate, requests in flight and the summary of every target
+func (c *control) statsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		live := liveStats{
+			Rate:          c.pacer.Rate(),
+			Paused:        c.pacer.Paused(),
+			WarmingUp:     time.Now().Before(c.stats.start),
+			InFlight:      atomic.LoadInt64(&c.stats.inFlight),
+			Dropped:       atomic.LoadInt64(&c.stats.dropped),
+			Delayed:       atomic.LoadInt64(&c.stats.delayed),
+			RetriesDenied: atomic.LoadInt64(&c.stats.retriesDenied),
+			Targets:       []summaryRecord{},
+		}
+		if c.users != nil {
+			live.Rate, live.Users = 0, c.users.Running()
+		}
+		for _, summary := range c.stats.Summaries() {
+			live.Targets = append(live.Targets, newSummaryRecord(summary))
+		}
+		writeJSON(w, start, http.StatusOK, live)
+	})
+}
+
+// pause stops sending requests until resumed
+func (c *control) pause() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if !allowMethods(w, r, start, http.MethodPost) || !c.authorize(w, r, start) {
+			return
+		}
+		if !c.pacer.Paused() {
+			c.pacer.Pause()
+			c.logger.Println("Paused by a control request")
+		}
+		writeStatus(w, start, http.StatusNoContent)
+	})
+}
+
+func (c *control) resume() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if !allowMethods(w, r, start, http.MethodPost) || !c.authorize(w, r, start) {
+			return
+		}
+		if c.pacer.Paused() {
+			c.pacer.Resume()
+			c.logger.Println("Resumed by a control request")
+		}
+		writeStatus(w, start, http.StatusNoContent)
+	})
+}
+
+// rate responds with the current rate, or changes it to the rate of a JSON body like {"rate": 50}
+func (c *control) rate() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if !allowMethods(w, r, start, http.MethodGet, http.MethodPut, http.MethodPost) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			if !c.authorize(w, r, start) {
+				return
+			}
+			if c.users != nil {
+				httpError(w, start, "the worker runs virtual users, it has no rate", http.StatusConflict)
+				return
+			}
+			if c.profiled {
+				httpError(w, start, "the rate is set by the load profile", http.StatusConflict)
+				return
+			}
+			if c.source != "" {
+				httpError(w, start, fmt.Sprintf("the requests are sent by %v, it ignores the rate", c.source), http.StatusConflict)
+				return
+			}
+			var req rateRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Rate == nil {
+				httpError(w, start, `invalid rate: expected {"rate": RATE}`, http.StatusBadRequest)
+				return
+			}
+			if rate := *req.Rate; rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+				httpError(w, start, "invalid rate: must be a non-negative number", http.StatusBadRequest)
+				return
+			}
+			c.logger.Printf("Rate changed from %v to %v rps by a control request", c.pacer.Rate(), *req.Rate)
+			c.pacer.Set(*req.Rate)
+		}
+		rate := c.pacer.Rate()
+		writeJSON(w, start, http.StatusOK, rateRequest{Rate: &rate})
+	})
+}
+
+// targets responds with the current targets, or replaces them with a JSON list of targets,
+// each either a `URL [WEIGHT]` string or a target object as accepted by --target
+func (c *control) targets() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if !allowMethods(w, r, start, http.MethodGet, http.MethodPut, http.MethodPost) {
+			return
+		}
+		if r.Method != http.MethodGet {
+			if !c.authorize(w, r, start) {
+				return
+			}
+			if c.source != "" {
+				httpError(w, start, fmt.Sprintf("the requests are sent by %v, it ignores the targets", c.source), http.StatusConflict)
+				return
+			}
+			targets, err := decodeTargets(r)
+			if err == nil {
+				for _, target := range targets {
+					target.inherit(c.defaults)
+					if err = target.compile(); err != nil {
+						break
+					}
+				}
+			}
+			if err != nil {
+				httpError(w, start, err.Error(), http.StatusBadRequest)
+				return
+			}
+			c.picker.Set(targets)
+			c.logger.Printf("Targets changed to %d targets by a control request", len(targets))
+		}
+		writeJSON(w, start, http.StatusOK, redactTargets(c.picker.Targets()))
+	})
+}
+
+// authorize responds with 403 Forbidden unless the worker can be steered, and with
+// 401 Unauthorized unless r has the bearer token when one is required
+func (c *control) authorize(w http.ResponseWriter, r *http.Request, start time.Time) bool {
+	if !c.writable {
+		httpError(w, start, "the control API is read-only, start the worker with --control to steer it", http.StatusForbidden)
+		return false
+	}
+	if c.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+c.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		httpError(w, start, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// redactTargets returns copies of targets with the values of credential headers redacted
+func redactTargets(targets []*Target) []*Target {
+	redacted := make([]*Target, 0, len(targets))
+	for _, target := range targets {
+		copied := *target
+		if target.Header != nil {
+			copied.Header = make(map[string]string, len(target.Header))
+			for k, v := range target.Header {
+				for _, sensitive := range sensitiveHeaders {
+					if strings.EqualFold(k, sensitive) {
+						v = "REDACTED"
+					}
+				}
+				copied.Header[k] = v
+			}
+		}
+		redacted = append(redacted, &copied)
+	}
+	return redacted
+}
+
+func decodeTargets(r *http.Request) ([]*Target, error) {
+	var specs []json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&specs); err != nil {
+		return nil, fmt.Errorf("invalid targets: %v", err)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("invalid targets: at least one target is required")
+	}
+	targets := make([]*Target, 0, len(specs))
+	for _, raw := range specs {
+		spec := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			spec = s
+		}
+		target, err := parseTarget(spec)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// allowMethods responds with 405 Method Not Allowed unless r uses one of methods
+func allowMethods(w http.ResponseWriter, r *http.Request, start time.Time, methods ...string) bool {
+	for _, m := range methods {
+		if r.Method == m {
+			return true
+		}
+	}
+	httpError(w, start, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, start time.Time, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	writeStatus(w, start, status)
+	json.NewEncoder(w).Encode(v)
+}
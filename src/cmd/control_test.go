@@ -0,0 +1,168 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	timerate "golang.org/x/time/rate"
+)
+
+func newTestControl(t *testing.T, profiled bool) *control {
+	t.Helper()
+	target, err := parseTarget("http://a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Header = map[string]string{"authorization": "Bearer s3cret", "Accept": "*/*"}
+	return &control{
+		pacer:    newRateController(timerate.NewLimiter(10, 1)),
+		picker:   newTargetPicker([]*Target{target}),
+		stats:    newStats(0),
+		defaults: &Target{Method: http.MethodPost},
+		profiled: profiled,
+		writable: true,
+		logger:   log.New(io.Discard, "", 0),
+	}
+}
+
+func Test_control_statsHandler(t *testing.T) {
+	c := newTestControl(t, false)
+	c.stats.Record(&Request{target: c.picker.Pick(), r: &http.Response{StatusCode: 200}, latency: time.Millisecond})
+	c.stats.InFlight(2)
+	w := httptest.NewRecorder()
+	c.statsHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stats", nil))
+	var got liveStats
+	if err := json.NewDecoder(w.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if w.Header().Get("X-Response-Code") != "200" || w.Header().Get("X-Request-Duration") == "" {
+		t.Errorf("control.statsHandler() headers = %v, want the response code and duration", w.Header())
+	}
+	if got.Rate != 10 || got.InFlight != 2 || len(got.Targets) != 1 || got.Targets[0].Codes[200] != 1 {
+		t.Errorf("control.statsHandler() = %+v, want rate 10, 2 in flight and a 200", got)
+	}
+}
+
+func Test_control(t *testing.T) {
+	tests := []struct {
+		name       string
+		profiled   bool
+		source     string
+		paused     bool
+		readOnly   bool
+		token      string
+		auth       string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+		check      func(c *control) bool
+	}{
+		{name: "pause", method: http.MethodPost, path: "/pause", wantStatus: http.StatusNoContent, check: func(c *control) bool { return c.pacer.Paused() }},
+		{name: "pause get", method: http.MethodGet, path: "/pause", wantStatus: http.StatusMethodNotAllowed},
+		{name: "resume", paused: true, method: http.MethodPost, path: "/resume", wantStatus: http.StatusNoContent, check: func(c *control) bool { return !c.pacer.Paused() }},
+		{name: "get rate", method: http.MethodGet, path: "/rate", wantStatus: http.StatusOK, wantBody: `{"rate":10}`},
+		{name: "set rate", method: http.MethodPut, path: "/rate", body: `{"rate": 2.5}`, wantStatus: http.StatusOK, wantBody: `{"rate":2.5}`,
+			check: func(c *control) bool { return c.pacer.Rate() == 2.5 }},
+		{name: "negative rate", method: http.MethodPut, path: "/rate", body: `{"rate": -1}`, wantStatus: http.StatusBadRequest},
+		{name: "missing rate", method: http.MethodPut, path: "/rate", body: `{}`, wantStatus: http.StatusBadRequest},
+		{name: "profiled rate", profiled: true, method: http.MethodPut, path: "/rate", body: `{"rate": 5}`, wantStatus: http.StatusConflict},
+		{name: "get targets", method: http.MethodGet, path: "/targets", wantStatus: http.StatusOK, wantBody: `"url":"http://a/"`},
+		{name: "get targets redacted", method: http.MethodGet, path: "/targets", wantStatus: http.StatusOK, wantBody: `"authorization":"REDACTED"`,
+			check: func(c *control) bool { return c.picker.Pick().Header["authorization"] == "Bearer s3cret" }},
+		{name: "read-only get", readOnly: true, method: http.MethodGet, path: "/rate", wantStatus: http.StatusOK, wantBody: `{"rate":10}`},
+		{name: "read-only pause", readOnly: true, method: http.MethodPost, path: "/pause", wantStatus: http.StatusForbidden,
+			check: func(c *control) bool { return !c.pacer.Paused() }},
+		{name: "read-only rate", readOnly: true, method: http.MethodPut, path: "/rate", body: `{"rate": 5}`, wantStatus: http.StatusForbidden,
+			check: func(c *control) bool { return c.pacer.Rate() == 10 }},
+		{name: "read-only targets", readOnly: true, method: http.MethodPut, path: "/targets", body: `["http://b/"]`, wantStatus: http.StatusForbidden,
+			check: func(c *control) bool { return c.picker.Pick().URL == "http://a/" }},
+		{name: "missing token", token: "t0ken", method: http.MethodPost, path: "/pause", wantStatus: http.StatusUnauthorized,
+			check: func(c *control) bool { return !c.pacer.Paused() }},
+		{name: "wrong token", token: "t0ken", auth: "Bearer other", method: http.MethodPut, path: "/rate", body: `{"rate": 5}`, wantStatus: http.StatusUnauthorized,
+			check: func(c *control) bool { return c.pacer.Rate() == 10 }},
+		{name: "token", token: "t0ken", auth: "Bearer t0ken", method: http.MethodPost, path: "/pause", wantStatus: http.StatusNoContent,
+			check: func(c *control) bool { return c.pacer.Paused() }},
+		{name: "set targets", method: http.MethodPut, path: "/targets", body: `["http://b/ 3", {"url": "http://c/", "method": "GET"}]`, wantStatus: http.StatusOK,
+			check: func(c *control) bool {
+				targets := c.picker.Targets()
+				return len(targets) == 2 && targets[0].Weight == 3 && targets[0].Method == http.MethodPost && targets[1].Method == http.MethodGet
+			}},
+		{name: "no targets", method: http.MethodPut, path: "/targets", body: `[]`, wantStatus: http.StatusBadRequest},
+		{name: "replay rate", source: "--replay", method: http.MethodPut, path: "/rate", body: `{"rate": 5}`, wantStatus: http.StatusConflict,
+			wantBody: "--replay", check: func(c *control) bool { return c.pacer.Rate() == 10 }},
+		{name: "replay targets", source: "--replay", method: http.MethodPut, path: "/targets", body: `["http://b/"]`, wantStatus: http.StatusConflict,
+			wantBody: "--replay", check: func(c *control) bool { return c.picker.Pick().URL == "http://a/" }},
+		{name: "har rate", source: "--har", method: http.MethodPut, path: "/rate", body: `{"rate": 5}`, wantStatus: http.StatusConflict,
+			wantBody: "--har", check: func(c *control) bool { return c.pacer.Rate() == 10 }},
+		{name: "har targets", source: "--har", method: http.MethodPut, path: "/targets", body: `["http://b/"]`, wantStatus: http.StatusConflict,
+			wantBody: "--har", check: func(c *control) bool { return c.picker.Pick().URL == "http://a/" }},
+		{name: "journey rate", source: "--journey", method: http.MethodPut, path: "/rate", body: `{"rate": 5}`, wantStatus: http.StatusConflict,
+			wantBody: "--journey", check: func(c *control) bool { return c.pacer.Rate() == 10 }},
+		{name: "journey targets", source: "--journey", method: http.MethodPut, path: "/targets", body: `["http://b/"]`, wantStatus: http.StatusConflict,
+			wantBody: "--journey", check: func(c *control) bool { return c.picker.Pick().URL == "http://a/" }},
+		{name: "invalid target", method: http.MethodPut, path: "/targets", body: `["http://b/ 0"]`, wantStatus: http.StatusBadRequest,
+			check: func(c *control) bool { return c.picker.Pick().URL == "http://a/" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestControl(t, tt.profiled)
+			c.writable, c.token, c.source = !tt.readOnly, tt.token, tt.source
+			if tt.paused {
+				c.pacer.Pause()
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/pause", c.pause())
+			mux.Handle("/resume", c.resume())
+			mux.Handle("/rate", c.rate())
+			mux.Handle("/targets", c.targets())
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			mux.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("%v %v = %v %v, want %v", tt.method, tt.path, w.Code, w.Body, tt.wantStatus)
+			}
+			if got := w.Header().Get("X-Response-Code"); got != strconv.Itoa(tt.wantStatus) || w.Header().Get("X-Request-Duration") == "" {
+				t.Errorf("%v %v X-Response-Code = %q, X-Request-Duration = %q, want both set", tt.method, tt.path, got, w.Header().Get("X-Request-Duration"))
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("%v %v = %v, want it to contain %v", tt.method, tt.path, w.Body, tt.wantBody)
+			}
+			if tt.check != nil && !tt.check(c) {
+				t.Errorf("%v %v left the worker in an unexpected state", tt.method, tt.path)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// results of workers can hold a histogram per target
+const maxResultsBytes = 32 << 20 // 32 MB
+
+type (
+	// Plan is the test plan a coordinator pushes to each of its workers
+	Plan struct {
+		Worker  string    `json:"worker"`
+		Workers int       `json:"workers"`
+		Targets []*Target `json:"targets"`
+		// Rate is the total rate and Profile the load profile of every worker combined,
+		// each worker sending Scale of it
+		Rate     float64       `json:"rate"`
+		Profile  string        `json:"profile"`
+		Scale    float64       `json:"scale"`
+		Duration time.Duration `json:"duration"`
+		// Requests is the share of this worker of the request limit, Idle is set when the
+		// limit is lower than the number of workers and this worker's share is none
+		Requests int64     `json:"requests"`
+		Idle     bool      `json:"idle,omitempty"`
+		Start    time.Time `json:"start"`
+
+		coordinator string
+	}
+	// coordinator hands out a plan to the expected number of workers once they have all
+	// registered, and collects their stats when they are done
+	coordinator struct {
+		plan       Plan
+		startDelay time.Duration
+		logger     *log.Logger
+
+		mu      sync.Mutex
+		workers []string
+		ready   chan struct{}
+		results map[string]StatsExport
+		first   chan struct{} // closed on the first results
+		done    chan struct{} // closed once every worker sent its results
+	}
+	registration struct {
+		Name   string `json:"name,omitempty"`
+		Worker string `json:"worker,omitempty"`
+	}
+)
+
+// coordinatorCmd represents the coordinator command
+var coordinatorCmd = &cobra.Command{
+	Use:   "coordinator",
+	Short: "Starts a coordinator distributing a test plan to workers",
+	PreRunE: validateFlags(
+		checkPort("port"),
+		checkRange(1, math.MaxInt32, "workers"),
+		checkRange(0, math.MaxInt32, "rate"),
+		checkNonNegative("requests"),
+		checkDuration("duration", "start-delay", "results-timeout"),
+	),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		port, _ := cmd.Flags().GetInt("port")
+		workers, _ := cmd.Flags().GetInt("workers")
+		rate, _ := cmd.Flags().GetInt("rate")
+		profileSpec, _ := cmd.Flags().GetString("profile")
+		duration, _ := cmd.Flags().GetDuration("duration")
+		requests, _ := cmd.Flags().GetInt64("requests")
+		startDelay, _ := cmd.Flags().GetDuration("start-delay")
+		resultsTimeout, _ := cmd.Flags().GetDuration("results-timeout")
+		thresholdExprs, _ := cmd.Flags().GetStringArray("threshold")
+
+		targets := getTargets(cmd.Flags(), "target")
+		if len(targets) == 0 {
+			return fmt.Errorf("at least one --target is required")
+		}
+		if _, err := parseProfile(profileSpec, float64(rate)); err != nil {
+			return err
+		}
+		thresholds, err := parseThresholds(thresholdExprs)
+		if err != nil {
+			return err
+		}
+
+		limits := defaultLimits()
+		// workers wait for the plan in long-polling requests
+		limits.WriteTimeout = 0
+		limits.MaxBodyBytes = maxResultsBytes
+		server := &Server{
+			port:   port,
+			name:   "Coordinator",
+			limits: limits,
+			stop:   make(chan struct{}),
+		}
+		server.logger = server.NewLogger()
+		server.router = server.NewRouter()
+
+		c := newCoordinator(Plan{
+			Workers:  workers,
+			Targets:  targets,
+			Rate:     float64(rate),
+			Profile:  profileSpec,
+			Duration: duration,
+			Requests: requests,
+		}, startDelay, server.logger)
+		server.router.Handle("/", notFound(time.Now()))
+		server.router.Handle("/healthz", healthz(0, server.Healthy))
+		server.router.Handle("/register", c.register())
+		server.router.Handle("/plan", c.planHandler())
+		server.router.Handle("/results", c.resultsHandler())
+
+		server.logger.Printf("Starting %v on port :%v, waiting for %d workers", server.name, server.port, workers)
+		go func() {
+			if c.waitResults(resultsTimeout) {
+				server.logger.Println("Every worker sent its results, stopping")
+			} else {
+				server.logger.Printf("Timed out waiting for the results of %v, stopping", strings.Join(c.Missing(), ", "))
+			}
+			server.Stop()
+		}()
+		server.Serve()
+
+		exports := c.Results()
+		if len(exports) == 0 {
+			server.logger.Println("No worker sent its results")
+			return nil
+		}
+		server.logger.Printf("Merged report of %d workers:", len(exports))
+		summaries := mergeExports(exports)
+		for _, summary := range summaries {
+			server.logger.Printf("[%v] %v", summary.Target, summary)
+		}
+		if _, failed := checkThresholds(thresholds, totalSummary(summaries), server.logger); failed > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d of %d thresholds failed", failed, len(thresholds))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(coordinatorCmd)
+
+	coordinatorCmd.Flags().IntP("port", "p", 8090, "port workers register on")
+	coordinatorCmd.Flags().IntP("workers", "w", 1, "number of workers to wait for before starting")
+	coordinatorCmd.Flags().VarP(&targetsValue{}, "target", "t", "target `URL [WEIGHT]` or JSON target to send requests to, can be repeated")
+	coordinatorCmd.Flags().IntP("rate", "r", 1, "total rate of requests per second, split evenly between workers")
+	coordinatorCmd.Flags().String("profile", "constant", "load profile of every worker combined, see worker --profile")
+	coordinatorCmd.Flags().Duration("duration", 0, "stop the workers after this long, 0 = run until they are interrupted")
+	coordinatorCmd.Flags().Int64("requests", 0, "total requests to send, split evenly between workers, 0 = no limit")
+	coordinatorCmd.Flags().Duration("start-delay", 2*time.Second, "delay between the last worker registering and the synchronized start")
+	coordinatorCmd.Flags().Duration("results-timeout", time.Minute, "how long to wait for the results of every worker after --duration, or after the first worker sent its results, before merging the results received")
+	coordinatorCmd.Flags().StringArray("threshold", nil, "`METRIC<VALUE` checked against the merged total when every worker is done, see worker --threshold")
+}
+
+func newCoordinator(plan Plan, startDelay time.Duration, logger *log.Logger) *coordinator {
+	return &coordinator{
+		plan:       plan,
+		startDelay: startDelay,
+		logger:     logger,
+		ready:      make(chan struct{}),
+		results:    map[string]StatsExport{},
+		first:      make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// register assigns an ID to a worker, and sets the start time once every worker has registered
+func (c *coordinator) register() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if !allowMethods(w, r, start, http.MethodPost) {
+			return
+		}
+		var reg registration
+		if err := json.NewDecoder(r.Body).Decode(&reg); err != nil {
+			httpError(w, start, "invalid registration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.mu.Lock()
+		if len(c.workers) == c.plan.Workers {
+			c.mu.Unlock()
+			httpError(w, start, "every worker has already registered", http.StatusConflict)
+			return
+		}
+		id := fmt.Sprintf("worker-%d", len(c.workers)+1)
+		c.workers = append(c.workers, id)
+		c.logger.Printf("Worker %v registered as %v, %d of %d", reg.Name, id, len(c.workers), c.plan.Workers)
+		if len(c.workers) == c.plan.Workers {
+			c.plan.Start = time.Now().Add(c.startDelay)
+			c.logger.Printf("Every worker registered, starting at %v", c.plan.Start.Format(time.RFC3339Nano))
+			close(c.ready)
+		}
+		c.mu.Unlock()
+		writeJSON(w, start, http.StatusOK, registration{Worker: id})
+	})
+}
+
+// planHandler responds with the plan of a worker once every worker has registered
+func (c *coordinator) planHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		select {
+		case <-c.ready:
+		case <-r.Context().Done():
+			return
+		}
+		plan, ok := c.planFor(r.URL.Query().Get("worker"))
+		if !ok {
+			httpError(w, start, "unknown worker", http.StatusNotFound)
+			return
+		}
+		writeJSON(w, start, http.StatusOK, plan)
+	})
+}
+
+// planFor returns the plan of a worker with its share of the rate and requests
+func (c *coordinator) planFor(worker string) (Plan, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, id := range c.workers {
+		if id != worker {
+			continue
+		}
+		plan := c.plan
+		plan.Worker = id
+		plan.Scale = 1 / float64(plan.Workers)
+		plan.Requests = c.plan.Requests / int64(plan.Workers)
+		if int64(i) < c.plan.Requests%int64(plan.Workers) {
+			plan.Requests++
+		}
+		// a limit of 0 is no limit
+		plan.Idle = c.plan.Requests > 0 && plan.Requests == 0
+		return plan, true
+	}
+	return Plan{}, false
+}
+
+// resultsHandler stores the stats of a worker
+func (c *coordinator) resultsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if !allowMethods(w, r, start, http.MethodPost) {
+			return
+		}
+		var export StatsExport
+		if err := json.NewDecoder(r.Body).Decode(&export); err != nil {
+			httpError(w, start, "invalid results: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		worker := r.URL.Query().Get("worker")
+		if _, ok := c.planFor(worker); !ok {
+			httpError(w, start, "unknown worker", http.StatusNotFound)
+			return
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		_, resent := c.results[worker]
+		c.results[worker] = export
+		c.logger.Printf("Received the results of %v, %d of %d", worker, len(c.results), c.plan.Workers)
+		if !resent && len(c.results) == 1 {
+			close(c.first)
+		}
+		if !resent && len(c.results) == c.plan.Workers {
+			close(c.done)
+		}
+		writeStatus(w, start, http.StatusNoContent)
+	})
+}
+
+// waitResults waits for the results of every worker, and returns false if some are
+// missing after timeout. The timeout starts at the end of the plan's duration or, without
+// a duration or with workers stopping early, when the first worker sent its results.
+func (c *coordinator) waitResults(timeout time.Duration) bool {
+	<-c.ready
+	var expired <-chan time.Time
+	if c.plan.Duration > 0 {
+		timer := time.NewTimer(time.Until(c.plan.Start.Add(c.plan.Duration + timeout)))
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case <-c.done:
+		return true
+	case <-expired:
+		return false
+	case <-c.first:
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-c.done:
+		return true
+	case <-expired:
+		return false
+	case <-timer.C:
+		return false
+	}
+}
+
+// Missing returns the workers that have not sent their results
+func (c *coordinator) Missing() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var missing []string
+	for _, id := range c.workers {
+		if _, ok := c.results[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// Results returns the stats received from workers, in registration order
+func (c *coordinator) Results() []StatsExport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	exports := make([]StatsExport, 0, len(c.results))
+	for _, id := range c.workers {
+		if export, ok := c.results[id]; ok {
+			exports = append(exports, export)
+		}
+	}
+	return exports
+}
+
+// joinCoordinator registers with the coordinator at base and waits for the plan
+func joinCoordinator(ctx context.Context, base, name string) (*Plan, error) {
+	base = strings.TrimSuffix(base, "/")
+	var reg registration
+	if err := coordinate(ctx, http.MethodPost, base+"/register", registration{Name: name}, &reg); err != nil {
+		return nil, err
+	}
+	plan := &Plan{}
+	if err := coordinate(ctx, http.MethodGet, base+"/plan?worker="+url.QueryEscape(reg.Worker), nil, plan); err != nil {
+		return nil, err
+	}
+	for _, target := range plan.Targets {
+		if err := target.init(); err != nil {
+			return nil, err
+		}
+	}
+	plan.coordinator = base
+	return plan, nil
+}
+
+// Report sends the stats of the worker to its coordinator
+func (p *Plan) Report(ctx context.Context, export StatsExport) error {
+	return coordinate(ctx, http.MethodPost, p.coordinator+"/results?worker="+url.QueryEscape(p.Worker), export, nil)
+}
+
+// coordinate sends in as JSON and decodes the response into out
+func coordinate(ctx context.Context, method, target string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("coordinator: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("coordinator: %v %v: %v %s", method, target, resp.Status, bytes.TrimSpace(msg))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,183 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_coordinator(t *testing.T) {
+	target, _ := parseTarget("http://a/")
+	c := newCoordinator(Plan{Workers: 3, Targets: []*Target{target}, Rate: 30, Requests: 10}, time.Second, log.New(io.Discard, "", 0))
+	mux := http.NewServeMux()
+	mux.Handle("/register", c.register())
+	mux.Handle("/plan", c.planHandler())
+	mux.Handle("/results", c.resultsHandler())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	plans := make(chan *Plan, 3)
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			plan, err := joinCoordinator(context.Background(), server.URL+"/", "test")
+			plans <- plan
+			errs <- err
+		}()
+	}
+	var requests int64
+	for i := 0; i < 3; i++ {
+		plan := <-plans
+		if err := <-errs; err != nil {
+			t.Fatalf("joinCoordinator() error = %v", err)
+		}
+		if plan.Scale != 1.0/3 || plan.Rate != 30 || len(plan.Targets) != 1 || plan.Targets[0].u == nil {
+			t.Errorf("joinCoordinator() = %+v, want a third of the plan with initialized targets", plan)
+		}
+		if until := time.Until(plan.Start); until <= 0 || until > time.Second {
+			t.Errorf("joinCoordinator() start in %v, want within the start delay", until)
+		}
+		requests += plan.Requests
+		if err := plan.Report(context.Background(), StatsExport{Elapsed: time.Second}); err != nil {
+			t.Errorf("Plan.Report() error = %v", err)
+		}
+	}
+	if requests != 10 {
+		t.Errorf("joinCoordinator() requests = %v, want a split of 10", requests)
+	}
+
+	if _, err := joinCoordinator(context.Background(), server.URL, "extra"); err == nil {
+		t.Error("joinCoordinator() once every worker registered = nil, want an error")
+	}
+	select {
+	case <-c.done:
+	default:
+		t.Error("coordinator not done once every worker reported")
+	}
+	if got := len(c.Results()); got != 3 {
+		t.Errorf("coordinator.Results() = %d results, want 3", got)
+	}
+}
+
+func Test_coordinator_responseHeaders(t *testing.T) {
+	c := newCoordinator(Plan{Workers: 1}, time.Second, log.New(io.Discard, "", 0))
+	tests := []struct {
+		name       string
+		handler    http.Handler
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{name: "register", handler: c.register(), method: http.MethodPost, target: "/register", body: `{"name": "test"}`, wantStatus: http.StatusOK},
+		{name: "register again", handler: c.register(), method: http.MethodPost, target: "/register", body: `{"name": "test"}`, wantStatus: http.StatusConflict},
+		{name: "invalid registration", handler: c.register(), method: http.MethodPost, target: "/register", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "register get", handler: c.register(), method: http.MethodGet, target: "/register", wantStatus: http.StatusMethodNotAllowed},
+		{name: "plan", handler: c.planHandler(), method: http.MethodGet, target: "/plan?worker=worker-1", wantStatus: http.StatusOK},
+		{name: "unknown plan", handler: c.planHandler(), method: http.MethodGet, target: "/plan?worker=worker-2", wantStatus: http.StatusNotFound},
+		{name: "results", handler: c.resultsHandler(), method: http.MethodPost, target: "/results?worker=worker-1", body: `{}`, wantStatus: http.StatusNoContent},
+		{name: "unknown results", handler: c.resultsHandler(), method: http.MethodPost, target: "/results?worker=worker-2", body: `{}`, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("%v %v = %v, want %v", tt.method, tt.target, w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("X-Response-Code"); got != strconv.Itoa(tt.wantStatus) || w.Header().Get("X-Request-Duration") == "" {
+				t.Errorf("%v %v X-Response-Code = %q, X-Request-Duration = %q, want both set", tt.method, tt.target, got, w.Header().Get("X-Request-Duration"))
+			}
+		})
+	}
+}
+
+func Test_coordinator_planFor(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests int64
+		worker   string
+		want     int64
+		wantIdle bool
+		wantOk   bool
+	}{
+		{name: "first share", requests: 5, worker: "worker-1", want: 2, wantOk: true},
+		{name: "second share", requests: 5, worker: "worker-2", want: 2, wantOk: true},
+		{name: "last share", requests: 5, worker: "worker-3", want: 1, wantOk: true},
+		{name: "unknown worker", requests: 5, worker: "worker-4"},
+		{name: "no limit", requests: 0, worker: "worker-3", want: 0, wantOk: true},
+		{name: "no share", requests: 2, worker: "worker-3", want: 0, wantIdle: true, wantOk: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCoordinator(Plan{Workers: 3, Requests: tt.requests}, 0, log.New(io.Discard, "", 0))
+			c.workers = []string{"worker-1", "worker-2", "worker-3"}
+			plan, ok := c.planFor(tt.worker)
+			if ok != tt.wantOk || plan.Requests != tt.want || plan.Idle != tt.wantIdle {
+				t.Errorf("coordinator.planFor() = %v idle=%v, %v, want %v idle=%v, %v", plan.Requests, plan.Idle, ok, tt.want, tt.wantIdle, tt.wantOk)
+			}
+		})
+	}
+}
+
+func Test_coordinator_waitResults(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration time.Duration
+		report   []string
+		want     bool
+		wantMiss []string
+	}{
+		{name: "every worker", report: []string{"worker-1", "worker-2"}, want: true},
+		{name: "crashed worker", report: []string{"worker-1"}, wantMiss: []string{"worker-2"}},
+		{name: "no results after the duration", duration: 10 * time.Millisecond, wantMiss: []string{"worker-1", "worker-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCoordinator(Plan{Workers: 2, Duration: tt.duration}, 0, log.New(io.Discard, "", 0))
+			for i := 0; i < 2; i++ {
+				c.register().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(`{"name": "test"}`)))
+			}
+			for _, worker := range tt.report {
+				c.resultsHandler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/results?worker="+worker, strings.NewReader(`{}`)))
+			}
+			start := time.Now()
+			if got := c.waitResults(50 * time.Millisecond); got != tt.want {
+				t.Errorf("coordinator.waitResults() = %v, want %v", got, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("coordinator.waitResults() took %v, want the results timeout", elapsed)
+			}
+			if got := c.Missing(); strings.Join(got, ",") != strings.Join(tt.wantMiss, ",") {
+				t.Errorf("coordinator.Missing() = %v, want %v", got, tt.wantMiss)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	arrivalsConstant = "constant"
+	arrivalsPoisson  = "poisson"
+
+	onFullDrop  = "drop"
+	onFullDelay = "delay"
+)
+
+// errRequestsDone is returned by a dispatcher after sending its limit of requests
+var errRequestsDone = errors.New("request limit reached")
+
+// dispatcher issues the worker's requests on the pacer's schedule. With a
+// maxInFlight of 0 requests are sent one at a time (closed loop), otherwise
+// they are sent concurrently into a pool of maxInFlight slots (open loop),
+// and requests that find the pool full are dropped or delayed. A limit
+// greater than 0 stops the dispatcher after sending that many requests.
+// With a replay, requests are sent on its schedule instead of the pacer's. With
+// a journey, each request of the schedule starts a run of the journey instead.
+// With users, requests are sent by virtual users thinking between them instead
+// of on a schedule (closed model), see runUsers.
+type dispatcher struct {
+	client       *RLHTTPClient
+	pacer        *rateController
+	picker       *targetPicker
+	replay       *replay
+	journey      *journey
+	users        *virtualUsers
+	think        thinkTime
+	feed         *feeder
+	fail         int
+	logger       *log.Logger
+	maxInFlight  int
+	dropWhenFull bool
+	limit        int64
+
+	pool chan struct{}
+}
+
+// Run dispatches requests until ctx is done, the feeder is exhausted or the limit
+// is reached, and returns once the requests in flight have completed
+func (d *dispatcher) Run(ctx context.Context) error {
+	if d.users != nil {
+		return d.runUsers(ctx)
+	}
+	if d.maxInFlight > 0 {
+		d.pool = make(chan struct{}, d.maxInFlight)
+		d.client.stats.SetPoolSize(d.maxInFlight)
+		defer d.drain()
+	}
+	var sent int64
+	for {
+		if d.limit > 0 && sent == d.limit {
+			return errRequestsDone
+		}
+		intended, target, err := d.next(ctx) // This is a blocking call. Honors the rate limit
+		if err == errReplayDone {
+			return err
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		var data map[string]interface{}
+		if d.feed != nil {
+			if data, err = d.feed.Next(); err != nil {
+				return err
+			}
+		}
+		if d.pool == nil {
+			d.client.stats.InFlight(1)
+			d.send(ctx, intended, target, data)
+			d.client.stats.InFlight(-1)
+			sent++
+			continue
+		}
+		if !d.acquire(ctx) {
+			continue
+		}
+		sent++
+		go func() {
+			defer d.release()
+			d.send(ctx, intended, target, data)
+		}()
+	}
+}
+
+// next waits for the next request and returns its target and intended send time
+func (d *dispatcher) next(ctx context.Context) (time.Time, *Target, error) {
+	if d.replay != nil {
+		return d.replay.Next(ctx, d.pacer)
+	}
+	intended, err := d.pacer.Wait(ctx)
+	if err != nil {
+		return intended, nil, err
+	}
+	return intended, d.picker.Pick(), nil
+}
+
+// send sends a request to target, or runs the journey
+func (d *dispatcher) send(ctx context.Context, intended time.Time, target *Target, data map[string]interface{}) {
+	if d.journey != nil {
+		// journeys interrupted by the worker stopping are not counted
+		if completed := d.journey.Run(ctx, d.client, intended, data, d.fail, d.logger); completed || ctx.Err() == nil {
+			d.client.stats.Journey(completed)
+		}
+		return
+	}
+	d.client.DoAt(ctx, intended, target, data, d.fail, d.logger)
+}
+
+// reserves a pool slot, returns false if the request was dropped
+func (d *dispatcher) acquire(ctx context.Context) bool {
+	select {
+	case d.pool <- struct{}{}:
+		d.client.stats.InFlight(1)
+		return true
+	default:
+	}
+	if d.dropWhenFull {
+		d.client.stats.Dropped()
+		return false
+	}
+	d.client.stats.Delayed()
+	select {
+	case d.pool <- struct{}{}:
+		d.client.stats.InFlight(1)
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// waits for the requests in flight by filling every slot of the pool
+func (d *dispatcher) drain() {
+	for i := 0; i < cap(d.pool); i++ {
+		d.pool <- struct{}{}
+	}
+}
+
+func (d *dispatcher) release() {
+	d.client.stats.InFlight(-1)
+	<-d.pool
+}
@@ -0,0 +1,140 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cam3ron2/example-app/src/faultserver"
+	"github.com/cam3ron2/example-app/src/faultserver/faultservertest"
+	timerate "golang.org/x/time/rate"
+)
+
+func newTestDispatcher(t *testing.T, delay time.Duration, rate float64) *dispatcher {
+	t.Helper()
+	srv := faultservertest.NewServer(t, faultserver.Faults{Delay: delay})
+	target, err := parseTarget(srv.URL() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := target.compile(); err != nil {
+		t.Fatal(err)
+	}
+	client := newClient(timerate.NewLimiter(timerate.Limit(rate), 1))
+	client.stats = newStats(0)
+	return &dispatcher{
+		client: client,
+		pacer:  newRateController(client.Ratelimiter),
+		picker: newTargetPicker([]*Target{target}),
+		logger: log.New(io.Discard, "", 0),
+	}
+}
+
+func Test_dispatcher_Run(t *testing.T) {
+	tests := []struct {
+		name         string
+		maxInFlight  int
+		dropWhenFull bool
+		wantDropped  bool
+		wantDelayed  bool
+	}{
+		{name: "closed loop", maxInFlight: 0},
+		{name: "drop when full", maxInFlight: 2, dropWhenFull: true, wantDropped: true},
+		{name: "delay when full", maxInFlight: 2, dropWhenFull: false, wantDelayed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDispatcher(t, 100*time.Millisecond, 100)
+			d.maxInFlight = tt.maxInFlight
+			d.dropWhenFull = tt.dropWhenFull
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+			if err := d.Run(ctx); err != context.DeadlineExceeded {
+				t.Errorf("dispatcher.Run() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+			if dropped := atomic.LoadInt64(&d.client.stats.dropped); (dropped > 0) != tt.wantDropped {
+				t.Errorf("dropped = %v, want dropped %v", dropped, tt.wantDropped)
+			}
+			if delayed := atomic.LoadInt64(&d.client.stats.delayed); (delayed > 0) != tt.wantDelayed {
+				t.Errorf("delayed = %v, want delayed %v", delayed, tt.wantDelayed)
+			}
+		})
+	}
+}
+
+func Test_dispatcher_Run_feeder(t *testing.T) {
+	d := newTestDispatcher(t, 0, 1000)
+	d.feed = &feeder{rows: []map[string]interface{}{{"n": "1"}, {"n": "2"}}, mode: feederOnce}
+	if err := d.Run(context.Background()); err != errFeederDone {
+		t.Errorf("dispatcher.Run() error = %v, want %v", err, errFeederDone)
+	}
+}
+
+func Test_dispatcher_Run_limit(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxInFlight int
+		users       int
+	}{
+		{name: "closed loop", maxInFlight: 0},
+		{name: "open loop", maxInFlight: 4},
+		{name: "virtual users", users: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDispatcher(t, 10*time.Millisecond, 1000)
+			d.maxInFlight = tt.maxInFlight
+			if tt.users > 0 {
+				d.users = newVirtualUsers(tt.users)
+			}
+			d.dropWhenFull = false
+			d.limit = 10
+			if err := d.Run(context.Background()); err != errRequestsDone {
+				t.Errorf("dispatcher.Run() error = %v, want %v", err, errRequestsDone)
+			}
+			// every request has completed once Run returns
+			if got := d.client.stats.Summaries()[0].Requests; got != 10 {
+				t.Errorf("requests = %v, want 10", got)
+			}
+		})
+	}
+}
+
+func Test_rateController_poisson(t *testing.T) {
+	rc := newRateController(timerate.NewLimiter(timerate.Limit(200), 1))
+	rc.poisson = true
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		if _, err := rc.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 100 arrivals at 200 rps average 500ms
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("100 poisson arrivals at 200 rps took %v, want ~500ms", elapsed)
+	}
+}
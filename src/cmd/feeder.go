@@ -0,0 +1,152 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	feederSequential = "sequential"
+	feederRandom     = "random"
+	feederOnce       = "once"
+)
+
+// errFeederDone is returned by a feeder in once mode after every row was used
+var errFeederDone = errors.New("feeder exhausted")
+
+// feeder hands out rows of a dataset to parameterize requests
+type feeder struct {
+	mu   sync.Mutex
+	rows []map[string]interface{}
+	mode string
+	next int
+}
+
+// loads a .csv file with a header row, a .ndjson/.jsonl file with one object per line or a .json
+// file with an array of objects
+func loadFeeder(path string, mode string) (*feeder, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var rows []map[string]interface{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		rows, err = readCSV(file)
+	case ".ndjson", ".jsonl":
+		rows, err = readNDJSON(file)
+	case ".json":
+		rows, err = readJSONArray(file)
+	default:
+		return nil, fmt.Errorf("unsupported feeder file %s: must be .csv, .ndjson, .jsonl or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read feeder file %s: %v", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("feeder file %s has no rows", path)
+	}
+	return &feeder{rows: rows, mode: mode}, nil
+}
+
+func readCSV(r io.Reader) ([]map[string]interface{}, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, field := range header {
+			row[field] = record[i]
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readNDJSON(r io.Reader) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var rows []map[string]interface{}
+	for {
+		var row map[string]interface{}
+		err := decoder.Decode(&row)
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func readJSONArray(r io.Reader) ([]map[string]interface{}, error) {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	var rows []map[string]interface{}
+	if err := decoder.Decode(&rows); err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("expected a single array of objects")
+	}
+	return rows, nil
+}
+
+// Next returns the row for the next request
+func (f *feeder) Next() (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch f.mode {
+	case feederRandom:
+		return f.rows[rand.Intn(len(f.rows))], nil
+	case feederOnce:
+		if f.next >= len(f.rows) {
+			return nil, errFeederDone
+		}
+	}
+	row := f.rows[f.next%len(f.rows)]
+	f.next++
+	return row, nil
+}
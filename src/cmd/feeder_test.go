@@ -0,0 +1,128 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func Test_loadFeeder(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    []map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name:    "csv",
+			file:    "users.csv",
+			content: "user_id,name\n1, alice\n2,bob\n",
+			want:    []map[string]interface{}{{"user_id": "1", "name": "alice"}, {"user_id": "2", "name": "bob"}},
+		},
+		{
+			name:    "ndjson",
+			file:    "users.ndjson",
+			content: "{\"user_id\": 1000000, \"name\": \"alice\"}\n{\"user_id\": 2, \"name\": \"bob\"}\n",
+			want:    []map[string]interface{}{{"user_id": json.Number("1000000"), "name": "alice"}, {"user_id": json.Number("2"), "name": "bob"}},
+		},
+		{
+			name:    "json array",
+			file:    "users.json",
+			content: "[\n  {\"user_id\": 1, \"name\": \"alice\"},\n  {\"user_id\": 2, \"name\": \"bob\"}\n]\n",
+			want:    []map[string]interface{}{{"user_id": json.Number("1"), "name": "alice"}, {"user_id": json.Number("2"), "name": "bob"}},
+		},
+		{name: "json objects", file: "users.json", content: "{\"user_id\": 1}\n{\"user_id\": 2}\n", wantErr: true},
+		{name: "json trailing data", file: "users.json", content: "[{\"user_id\": 1}]\n{\"user_id\": 2}\n", wantErr: true},
+		{name: "ragged csv", file: "users.csv", content: "user_id,name\n1\n", wantErr: true},
+		{name: "empty", file: "users.csv", content: "user_id,name\n", wantErr: true},
+		{name: "bad json", file: "users.jsonl", content: "{\"user_id\": \n", wantErr: true},
+		{name: "unknown extension", file: "users.txt", content: "1\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadFeeder(writeTempFile(t, tt.file, tt.content), feederSequential)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadFeeder() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got.rows) != len(tt.want) {
+				t.Fatalf("loadFeeder() rows = %v, want %v", got.rows, tt.want)
+			}
+			for i := range tt.want {
+				for k, v := range tt.want[i] {
+					if got.rows[i][k] != v {
+						t.Errorf("loadFeeder() row %d %s = %v, want %v", i, k, got.rows[i][k], v)
+					}
+				}
+			}
+		})
+	}
+}
+
+func Test_feeder_Next(t *testing.T) {
+	rows := []map[string]interface{}{{"n": "1"}, {"n": "2"}}
+	tests := []struct {
+		name    string
+		mode    string
+		want    []string
+		wantErr bool
+	}{
+		{name: "sequential wraps around", mode: feederSequential, want: []string{"1", "2", "1"}},
+		{name: "once stops", mode: feederOnce, want: []string{"1", "2"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &feeder{rows: rows, mode: tt.mode}
+			for _, want := range tt.want {
+				row, err := f.Next()
+				if err != nil || row["n"] != want {
+					t.Fatalf("feeder.Next() = %v, %v, want %v", row, err, want)
+				}
+			}
+			if _, err := f.Next(); (err == errFeederDone) != tt.wantErr {
+				t.Errorf("feeder.Next() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	f := &feeder{rows: rows, mode: feederRandom}
+	for i := 0; i < 10; i++ {
+		if _, err := f.Next(); err != nil {
+			t.Errorf("feeder.Next() random error = %v", err)
+		}
+	}
+}
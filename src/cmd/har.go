@@ -0,0 +1,199 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// harFile is the part of an HTTP Archive, as exported by browsers, that is replayed
+	harFile struct {
+		Log struct {
+			Entries []harEntry `json:"entries"`
+		} `json:"log"`
+	}
+	harEntry struct {
+		StartedDateTime time.Time  `json:"startedDateTime"`
+		Request         harRequest `json:"request"`
+	}
+	harRequest struct {
+		Method   string         `json:"method"`
+		URL      string         `json:"url"`
+		Headers  []harNameValue `json:"headers"`
+		PostData *harPostData   `json:"postData"`
+	}
+	harPostData struct {
+		MimeType string         `json:"mimeType"`
+		Text     string         `json:"text"`
+		Params   []harNameValue `json:"params"`
+		Encoding string         `json:"encoding"` // not in the spec, "base64" from some exporters
+	}
+	harNameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+
+	// harOptions select the HAR entries to replay and where to send them
+	harOptions struct {
+		hosts   map[string]*url.URL // host of the entries => scheme and host to send to
+		domains []string            // only entries for these domains or their subdomains, only the rewritten hosts if empty
+	}
+)
+
+// loadHAR reads the entries of a HAR file
+func loadHAR(path string) ([]harEntry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var har harFile
+	if err := json.Unmarshal(b, &har); err != nil {
+		return nil, fmt.Errorf("invalid HAR file %s: %v", path, err)
+	}
+	if len(har.Log.Entries) == 0 {
+		return nil, fmt.Errorf("HAR file %s has no entries", path)
+	}
+	return har.Log.Entries, nil
+}
+
+// parseHostRewrites parses OLD=NEW host rewrites, NEW is a host or a URL also setting the scheme
+func parseHostRewrites(rewrites []string) (map[string]*url.URL, error) {
+	hosts := map[string]*url.URL{}
+	for _, rewrite := range rewrites {
+		old, host, ok := strings.Cut(rewrite, "=")
+		old, host = strings.TrimSpace(old), strings.TrimSpace(host)
+		if !ok || old == "" || host == "" {
+			return nil, fmt.Errorf("invalid --har-host %q: expected OLD=NEW, ex www.example.com=localhost:8080 or www.example.com=http://localhost:8080", rewrite)
+		}
+		to := &url.URL{Host: host}
+		if strings.Contains(host, "://") {
+			u, err := url.Parse(host)
+			if err != nil || u.Host == "" || strings.Trim(u.Path, "/") != "" || (u.Scheme != "http" && u.Scheme != "https") {
+				return nil, fmt.Errorf("invalid --har-host %q: expected a host or an http(s) URL without a path", rewrite)
+			}
+			to = &url.URL{Scheme: u.Scheme, Host: u.Host}
+		}
+		hosts[strings.ToLower(old)] = to
+	}
+	return hosts, nil
+}
+
+// matchesDomain tells if host is one of domains or one of their subdomains
+func matchesDomain(host string, domains []string) bool {
+	host = strings.ToLower(host)
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.Trim(domain, "."))
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// newHARReplay replays the requests of HAR entries at their (rewritten) URLs, keeping the
+// time between them. Entries that are not HTTP(S) requests are skipped, like data: URLs.
+// Headers and auth of defaults override the entries' ones and stats are kept per scheme and host.
+func newHARReplay(entries []harEntry, opts harOptions, defaults *Target, speed float64) (*replay, error) {
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].StartedDateTime.Before(entries[j].StartedDateTime) })
+	r := &replay{speed: speed}
+	var first time.Time
+	for _, entry := range entries {
+		req := entry.Request
+		u, err := url.Parse(req.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HAR entry %v %v: %v", req.Method, req.URL, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			continue
+		}
+		if len(opts.domains) > 0 && !matchesDomain(u.Hostname(), opts.domains) {
+			continue
+		}
+		to, ok := opts.hosts[strings.ToLower(u.Host)]
+		if !ok {
+			to, ok = opts.hosts[strings.ToLower(u.Hostname())]
+		}
+		if !ok && len(opts.domains) == 0 {
+			// hosts that are not rewritten are only load tested when asked for by domain
+			continue
+		}
+		if ok {
+			u.Host = to.Host
+			if to.Scheme != "" {
+				u.Scheme = to.Scheme
+			}
+		}
+		u.Fragment, u.RawFragment = "", ""
+		body, err := req.PostData.body()
+		if err != nil {
+			return nil, fmt.Errorf("invalid body of HAR entry %v %v: %v", req.Method, req.URL, err)
+		}
+		header := http.Header{}
+		for _, h := range req.Headers {
+			// HTTP/2 pseudo-headers, ex :authority
+			if !strings.HasPrefix(h.Name, ":") {
+				header.Add(h.Name, h.Value)
+			}
+		}
+		name := fmt.Sprintf("%s://%s/", u.Scheme, u.Host)
+		target, err := replayTarget(strings.ToUpper(req.Method), u, header, body, defaults.Header, defaults.Check, defaults.auth, name)
+		if err != nil {
+			return nil, err
+		}
+		if first.IsZero() {
+			first = entry.StartedDateTime
+		}
+		r.requests = append(r.requests, replayedRequest{offset: entry.StartedDateTime.Sub(first), target: target})
+	}
+	if len(r.requests) == 0 {
+		return nil, fmt.Errorf("no HAR entries to replay, check --har-domain and --har-host")
+	}
+	return r, nil
+}
+
+// body of a request, its text or else its form parameters
+func (d *harPostData) body() ([]byte, error) {
+	switch {
+	case d == nil:
+		return nil, nil
+	case d.Text != "" && d.Encoding == "base64":
+		return base64.StdEncoding.DecodeString(d.Text)
+	case d.Text != "":
+		return []byte(d.Text), nil
+	case len(d.Params) > 0:
+		form := url.Values{}
+		for _, p := range d.Params {
+			form.Add(p.Name, p.Value)
+		}
+		return []byte(form.Encode()), nil
+	}
+	return nil, nil
+}
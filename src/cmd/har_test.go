@@ -0,0 +1,163 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+const testHAR = `{"log": {"version": "1.2", "entries": [
+	{"startedDateTime": "2022-06-01T10:00:01.500Z", "request": {"method": "POST", "url": "https://api.example.com/orders#top",
+		"headers": [{"name": ":authority", "value": "api.example.com"}, {"name": "content-type", "value": "application/x-www-form-urlencoded"}, {"name": "cookie", "value": "a=1"}, {"name": "cookie", "value": "b=2"}],
+		"postData": {"mimeType": "application/x-www-form-urlencoded", "params": [{"name": "sku", "value": "A1"}, {"name": "qty", "value": "2"}]}}},
+	{"startedDateTime": "2022-06-01T10:00:00Z", "request": {"method": "GET", "url": "https://www.example.com/?q=shoes",
+		"headers": [{"name": "accept", "value": "text/html"}, {"name": "x-tpl", "value": "{{ uuid }}"}]}},
+	{"startedDateTime": "2022-06-01T10:00:00.200Z", "request": {"method": "GET", "url": "https://cdn.other.net/app.js", "headers": []}},
+	{"startedDateTime": "2022-06-01T10:00:00.300Z", "request": {"method": "GET", "url": "data:image/png;base64,AAAA", "headers": []}}
+]}}`
+
+func Test_loadHAR(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    int
+		wantErr bool
+	}{
+		{name: "entries", content: testHAR, want: 4},
+		{name: "no entries", content: `{"log": {"entries": []}}`, wantErr: true},
+		{name: "invalid", content: `{"log": `, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadHAR(writeTempFile(t, "session.har", tt.content))
+			if (err != nil) != tt.wantErr || len(got) != tt.want {
+				t.Errorf("loadHAR() = %d entries, %v, want %d entries, error %v", len(got), err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseHostRewrites(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite string
+		want    string
+		wantErr bool
+	}{
+		{name: "host", rewrite: "www.example.com=localhost:8080", want: "//localhost:8080"},
+		{name: "url", rewrite: "WWW.example.com=http://localhost:8080/", want: "http://localhost:8080"},
+		{name: "missing host", rewrite: "www.example.com=", wantErr: true},
+		{name: "path", rewrite: "www.example.com=http://localhost/v2", wantErr: true},
+		{name: "scheme", rewrite: "www.example.com=ftp://localhost", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseHostRewrites([]string{tt.rewrite})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseHostRewrites() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got["www.example.com"].String() != tt.want {
+				t.Errorf("parseHostRewrites() = %v, want %v", got["www.example.com"], tt.want)
+			}
+		})
+	}
+}
+
+func Test_newHARReplay(t *testing.T) {
+	entries, err := loadHAR(writeTempFile(t, "session.har", testHAR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, _ := parseHostRewrites([]string{"api.example.com=http://localhost:8080"})
+	defaults := &Target{Header: map[string]string{"Accept": "*/*"}}
+	r, err := newHARReplay(entries, harOptions{hosts: hosts, domains: []string{"example.com"}}, defaults, 1)
+	if err != nil {
+		t.Fatalf("newHARReplay() error = %v", err)
+	}
+	if len(r.requests) != 2 {
+		t.Fatalf("newHARReplay() = %d requests, want the 2 for example.com", len(r.requests))
+	}
+	if got := r.Names(); len(got) != 2 || got[0] != "https://www.example.com/" || got[1] != "http://localhost:8080/" {
+		t.Errorf("replay.Names() = %v, want the scheme and host of each request", got)
+	}
+
+	tests := []struct {
+		name       string
+		i          int
+		wantMethod string
+		wantURL    string
+		wantOffset time.Duration
+		wantHeader map[string]string
+		wantBody   string
+	}{
+		{name: "first", i: 0, wantMethod: "GET", wantURL: "https://www.example.com/?q=shoes",
+			wantHeader: map[string]string{"Accept": "*/*", "X-Tpl": "{{ uuid }}"}},
+		{name: "rewritten", i: 1, wantMethod: "POST", wantURL: "http://localhost:8080/orders", wantOffset: 1500 * time.Millisecond,
+			wantHeader: map[string]string{"Cookie": "a=1; b=2", "Content-Type": "application/x-www-form-urlencoded", ":authority": ""},
+			wantBody:   "qty=2&sku=A1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replayed := r.requests[tt.i]
+			if replayed.offset != tt.wantOffset {
+				t.Errorf("replayed request offset = %v, want %v", replayed.offset, tt.wantOffset)
+			}
+			req, err := replayed.target.newRequest(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.Method != tt.wantMethod || req.URL.String() != tt.wantURL {
+				t.Errorf("request = %v %v, want %v %v", req.Method, req.URL, tt.wantMethod, tt.wantURL)
+			}
+			for k, want := range tt.wantHeader {
+				if got := req.Header.Get(k); got != want {
+					t.Errorf("header %v = %q, want %q", k, got, want)
+				}
+			}
+			body := ""
+			if req.Body != nil {
+				b, _ := io.ReadAll(req.Body)
+				body = string(b)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+
+	// without domains only the rewritten hosts are replayed
+	r, err = newHARReplay(entries, harOptions{hosts: hosts}, defaults, 1)
+	if err != nil {
+		t.Fatalf("newHARReplay() without domains error = %v", err)
+	}
+	if got := r.Names(); len(got) != 1 || got[0] != "http://localhost:8080/" {
+		t.Errorf("replay.Names() without domains = %v, want only the rewritten host", got)
+	}
+	if _, err := newHARReplay(entries, harOptions{}, defaults, 1); err == nil {
+		t.Error("newHARReplay() without domains or hosts error = nil, want an error")
+	}
+	if _, err := newHARReplay(entries, harOptions{domains: []string{"nowhere.org"}}, defaults, 1); err == nil {
+		t.Error("newHARReplay() without matching entries error = nil, want an error")
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type (
+	// journey is a scenario of ordered steps run by a virtual user with its own cookie jar.
+	// Values extracted from the responses of a step are available to the templates of the
+	// next ones, ex {{ .token }}, and a step that fails aborts the rest of the journey.
+	journey struct {
+		Name  string         `json:"name"`
+		Steps []*journeyStep `json:"steps"`
+	}
+	// journeyStep is a request with the options of a target, extracting values from its
+	// response and pausing for its think time before the next step
+	journeyStep struct {
+		Target
+		Name    string            `json:"name"`
+		Extract map[string]string `json:"extract"`
+		Think   string            `json:"think"`
+
+		extractors []extractor
+		think      thinkTime
+	}
+	// extractor sets a variable from a response, with a JSON path, a regular expression
+	// (its first group, or else the whole match) or a header
+	extractor struct {
+		name   string
+		kind   string
+		path   string
+		header string
+		regex  *regexp.Regexp
+	}
+)
+
+// loadJourney reads a journey from a YAML or JSON file, its steps inherit the
+// request options of defaults. Its name defaults to the file name.
+func loadJourney(path string, defaults *Target) (*journey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	// decoded as YAML, a superset of JSON, and mapped to the JSON keys of targets
+	var doc interface{}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("invalid journey %s: %v", path, err)
+	}
+	if b, err = json.Marshal(doc); err != nil {
+		return nil, fmt.Errorf("invalid journey %s: %v", path, err)
+	}
+	j := &journey{}
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, fmt.Errorf("invalid journey %s: %v", path, err)
+	}
+	if j.Name == "" {
+		j.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if len(j.Steps) == 0 {
+		return nil, fmt.Errorf("journey %s has no steps", path)
+	}
+	names := map[string]bool{}
+	for i, step := range j.Steps {
+		if step.Name == "" {
+			step.Name = fmt.Sprintf("step-%d", i+1)
+		}
+		if names[step.Name] {
+			return nil, fmt.Errorf("invalid journey %s: duplicate step %s", path, step.Name)
+		}
+		names[step.Name] = true
+		if err := step.init(j.Name, defaults); err != nil {
+			return nil, fmt.Errorf("invalid journey %s step %s: %v", path, step.Name, err)
+		}
+	}
+	return j, nil
+}
+
+// init compiles the step's request, extractors and think time, its stats are named journey/step
+func (s *journeyStep) init(journey string, defaults *Target) error {
+	if s.Weight == 0 {
+		s.Weight = 1
+	}
+	if err := s.Target.init(); err != nil {
+		return err
+	}
+	s.Target.inherit(defaults)
+	if err := s.Target.compile(); err != nil {
+		return err
+	}
+	s.Target.name = journey + "/" + s.Name
+	for name, spec := range s.Extract {
+		e, err := parseExtractor(name, spec)
+		if err != nil {
+			return err
+		}
+		s.extractors = append(s.extractors, e)
+	}
+	var err error
+	s.think, err = parseThinkTime(s.Think)
+	return err
+}
+
+// parseExtractor parses json:PATH, regex:EXPR or header:NAME
+func parseExtractor(name, spec string) (extractor, error) {
+	kind, arg, _ := strings.Cut(spec, ":")
+	e := extractor{name: name, kind: kind}
+	switch kind {
+	case "json":
+		e.path = arg
+	case "header":
+		e.header = http.CanonicalHeaderKey(strings.TrimSpace(arg))
+	case "regex":
+		var err error
+		if e.regex, err = regexp.Compile(arg); err != nil {
+			return e, fmt.Errorf("invalid extract %s regex %q: %v", name, arg, err)
+		}
+	default:
+		return e, fmt.Errorf("invalid extract %s %q: expected json:PATH, regex:EXPR or header:NAME", name, spec)
+	}
+	if arg == "" {
+		return e, fmt.Errorf("invalid extract %s %q: expected json:PATH, regex:EXPR or header:NAME", name, spec)
+	}
+	return e, nil
+}
+
+// Extract returns the value of the extractor in a response, false if it is missing
+func (e extractor) Extract(resp *http.Response, body []byte) (string, bool) {
+	switch e.kind {
+	case "json":
+		var doc interface{}
+		if err := json.Unmarshal(body, &doc); err != nil {
+			return "", false
+		}
+		v, ok := jsonPath(doc, e.path)
+		if !ok || v == nil {
+			return "", false
+		}
+		if s, ok := v.(string); ok {
+			return s, true
+		}
+		return marshalCanonical(v), true
+	case "header":
+		v := resp.Header.Get(e.header)
+		return v, v != ""
+	default:
+		m := e.regex.FindSubmatch(body)
+		if m == nil {
+			return "", false
+		}
+		if len(m) > 1 {
+			return string(m[1]), true
+		}
+		return string(m[0]), true
+	}
+}
+
+// Run runs the journey once as a new virtual user, the first request is intended to be sent
+// at intended. data, a feeder row, is available to templates along with extracted values.
+// It returns false if a step failed and the rest of the journey was skipped.
+func (j *journey) Run(ctx context.Context, c *RLHTTPClient, intended time.Time, data map[string]interface{}, percentage int, logger *log.Logger) bool {
+	jar, _ := cookiejar.New(nil)
+	vars := map[string]interface{}{}
+	for k, v := range data {
+		vars[k] = v
+	}
+	for i, step := range j.Steps {
+		if i > 0 {
+			intended = time.Now()
+		}
+		req := &Request{target: &step.Target, intended: intended, jar: jar, ctx: ctx}
+		if len(step.extractors) > 0 {
+			req.extract = func(req *Request) []string {
+				var failed []string
+				for _, e := range step.extractors {
+					if v, ok := e.Extract(req.r, req.body); ok {
+						vars[e.name] = v
+					} else {
+						failed = append(failed, "extract:"+e.name)
+					}
+				}
+				return failed
+			}
+		}
+		c.do(req, vars, percentage, logger)
+		if req.e != nil || len(req.failed) > 0 {
+			return false
+		}
+		if i < len(j.Steps)-1 && !sleepUntil(ctx, time.Now().Add(step.think.Next())) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,198 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_loadJourney(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		wantName  string
+		wantSteps []string
+		wantErr   bool
+	}{
+		{
+			name: "yaml",
+			content: `
+steps:
+  - name: login
+    url: http://shop/login
+    method: POST
+    body-template: '{"user": "{{ .user }}"}'
+    extract:
+      token: json:data.token
+    think: 1s
+  - url: http://shop/items
+`,
+			wantName:  "checkout",
+			wantSteps: []string{"checkout/login", "checkout/step-2"},
+		},
+		{name: "json", content: `{"name": "browse", "steps": [{"url": "http://shop/"}]}`, wantName: "browse", wantSteps: []string{"browse/step-1"}},
+		{name: "no steps", content: `name: empty`, wantErr: true},
+		{name: "duplicate step", content: `{"steps": [{"name": "a", "url": "http://shop/"}, {"name": "a", "url": "http://shop/"}]}`, wantErr: true},
+		{name: "invalid extract", content: `{"steps": [{"url": "http://shop/", "extract": {"id": "xpath://id"}}]}`, wantErr: true},
+		{name: "invalid think", content: `{"steps": [{"url": "http://shop/", "think": "soon"}]}`, wantErr: true},
+		{name: "invalid url", content: `{"steps": [{"url": "ftp://shop/"}]}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := loadJourney(writeTempFile(t, "checkout.yaml", tt.content), &Target{Method: http.MethodGet})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadJourney() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Name != tt.wantName || len(got.Steps) != len(tt.wantSteps) {
+				t.Fatalf("loadJourney() = %v with %d steps, want %v with %d", got.Name, len(got.Steps), tt.wantName, len(tt.wantSteps))
+			}
+			for i, step := range got.Steps {
+				if step.Target.Name() != tt.wantSteps[i] {
+					t.Errorf("step %d name = %v, want %v", i, step.Target.Name(), tt.wantSteps[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_extractor_Extract(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"X-Csrf-Token": {"abc"}}}
+	body := []byte(`{"data": {"token": "t0k", "items": [{"id": 42}]}}`)
+	tests := []struct {
+		name   string
+		spec   string
+		want   string
+		wantOK bool
+	}{
+		{name: "json string", spec: "json:data.token", want: "t0k", wantOK: true},
+		{name: "json number", spec: "json:data.items.0.id", want: "42", wantOK: true},
+		{name: "json missing", spec: "json:data.user"},
+		{name: "header", spec: "header:x-csrf-token", want: "abc", wantOK: true},
+		{name: "missing header", spec: "header:X-Session"},
+		{name: "regex group", spec: `regex:"id":\s*(\d+)`, want: "42", wantOK: true},
+		{name: "regex match", spec: `regex:t\dk`, want: "t0k", wantOK: true},
+		{name: "regex no match", spec: `regex:"user"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := parseExtractor("v", tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := e.Extract(resp, body); got != tt.want || ok != tt.wantOK {
+				t.Errorf("extractor.Extract() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func Test_journey_Run(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s-" + r.URL.Query().Get("user"), Path: "/"})
+		fmt.Fprint(w, `{"token": "t0k"}`)
+	})
+	mux.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"items": [{"id": 42}, {"id": 43}]}`)
+	})
+	mux.HandleFunc("/items/", func(w http.ResponseWriter, r *http.Request) {
+		cookie, _ := r.Cookie("session")
+		mu.Lock()
+		seen = append(seen, fmt.Sprintf("%v %v %v", r.URL.Path, cookie.Value, r.Header.Get("Authorization")))
+		mu.Unlock()
+	})
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, r *http.Request) {})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name          string
+		missing       string
+		wantCompleted bool
+		wantSeen      []string
+		wantRequests  int64
+	}{
+		{name: "completed", missing: "json:items.1.id", wantCompleted: true, wantSeen: []string{"/items/42 s-alice Bearer t0k"}, wantRequests: 4},
+		{name: "aborted by a failed extraction", missing: "header:X-Total", wantRequests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			path := writeTempFile(t, "shop.yaml", strings.NewReplacer("SRV", srv.URL, "MISSING", tt.missing).Replace(`
+steps:
+  - name: login
+    url: SRV/login?user={{ .user }}
+    method: POST
+    extract:
+      token: json:token
+  - name: list
+    url: SRV/items
+    extract:
+      item: json:items.0.id
+      other: MISSING
+  - name: item
+    url: SRV/items/{{ .item }}
+    header:
+      Authorization: Bearer {{ .token }}
+    think: 10ms
+  - name: logout
+    url: SRV/logout
+`))
+			j, err := loadJourney(path, &Target{Check: Checks{Status: "200"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := newClient(nil)
+			c.stats = newStats(0)
+			start := time.Now()
+			if got := j.Run(context.Background(), c, time.Now(), map[string]interface{}{"user": "alice"}, 0, log.New(io.Discard, "", 0)); got != tt.wantCompleted {
+				t.Errorf("journey.Run() = %v, want %v", got, tt.wantCompleted)
+			}
+			if tt.wantCompleted && time.Since(start) < 10*time.Millisecond {
+				t.Errorf("journey.Run() took %v, want at least the 10ms think time", time.Since(start))
+			}
+			if fmt.Sprint(seen) != fmt.Sprint(tt.wantSeen) {
+				t.Errorf("requests = %v, want %v", seen, tt.wantSeen)
+			}
+			summaries := c.stats.Summaries()
+			if total := summaries[len(summaries)-1]; total.Requests != tt.wantRequests {
+				t.Errorf("requests sent = %d, want %d", total.Requests, tt.wantRequests)
+			}
+		})
+	}
+}
@@ -0,0 +1,473 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	timerate "golang.org/x/time/rate"
+)
+
+// how often a load profile updates the request rate
+const profileTick = 100 * time.Millisecond
+
+type (
+	// loadProfile describes the request rate over the life of the worker
+	loadProfile interface {
+		// At returns the rate at elapsed time since the start and the name of the
+		// current stage, done is true once the profile is over
+		At(elapsed time.Duration) (rate float64, stage string, done bool)
+	}
+	// a stage holds or ramps to rate over duration
+	stage struct {
+		duration time.Duration
+		rate     float64
+	}
+	constantProfile struct {
+		rate float64
+	}
+	// rampProfile linearly ramps from start to the rate of each stage in turn
+	rampProfile struct {
+		start  float64
+		stages []stage
+	}
+	// stepProfile holds the rate of each stage in turn
+	stepProfile struct {
+		stages []stage
+	}
+	// spikeProfile jumps from base to peak for length, once every period
+	spikeProfile struct {
+		base   float64
+		peak   float64
+		length time.Duration
+		every  time.Duration
+	}
+	// sineProfile oscillates between min and max, starting at min
+	sineProfile struct {
+		min    float64
+		max    float64
+		period time.Duration
+	}
+	// replayProfile replays a time series of rates, each held until the next point
+	replayProfile struct {
+		stages []stage
+	}
+	// scaledProfile sends a share of the rate of a profile, ex a worker's share of
+	// the profile of a coordinator
+	scaledProfile struct {
+		loadProfile
+		scale float64
+	}
+	// rateController schedules requests at a rate starting at the limiter's, pausing
+	// requests while the rate is 0. With poisson set, requests arrive at exponentially
+	// distributed intervals averaging the rate instead of at a constant interval.
+	rateController struct {
+		limiter *timerate.Limiter
+		rate    uint64 // math.Float64bits of the current rate
+		paused  int32
+		poisson bool
+
+		mu      sync.Mutex
+		next    time.Time
+		planned float64 // rate next was planned at
+	}
+)
+
+// parses a --profile spec, rate is the starting rate set with --rate
+func parseProfile(spec string, rate float64) (loadProfile, error) {
+	kind, args, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "", "constant":
+		return constantProfile{rate: rate}, nil
+	case "ramp":
+		stages, err := parseStages(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ramp profile %q: %v", spec, err)
+		}
+		return rampProfile{start: rate, stages: stages}, nil
+	case "step":
+		stages, err := parseStages(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step profile %q: %v", spec, err)
+		}
+		return stepProfile{stages: stages}, nil
+	case "spike":
+		opts, err := parseOptions(args, "peak", "length", "every")
+		if err != nil {
+			return nil, fmt.Errorf("invalid spike profile %q: %v", spec, err)
+		}
+		p := spikeProfile{base: rate}
+		if p.peak, err = parseRate(opts["peak"]); err != nil {
+			return nil, fmt.Errorf("invalid spike profile %q: %v", spec, err)
+		}
+		if p.length, err = time.ParseDuration(opts["length"]); err != nil {
+			return nil, fmt.Errorf("invalid spike profile %q: %v", spec, err)
+		}
+		if p.every, err = time.ParseDuration(opts["every"]); err != nil {
+			return nil, fmt.Errorf("invalid spike profile %q: %v", spec, err)
+		}
+		if p.length <= 0 || p.every <= p.length {
+			return nil, fmt.Errorf("invalid spike profile %q: length must be positive and shorter than every", spec)
+		}
+		return p, nil
+	case "sine":
+		opts, err := parseOptions(args, "min", "max", "period")
+		if err != nil {
+			return nil, fmt.Errorf("invalid sine profile %q: %v", spec, err)
+		}
+		var p sineProfile
+		if p.min, err = parseRate(opts["min"]); err != nil {
+			return nil, fmt.Errorf("invalid sine profile %q: %v", spec, err)
+		}
+		if p.max, err = parseRate(opts["max"]); err != nil {
+			return nil, fmt.Errorf("invalid sine profile %q: %v", spec, err)
+		}
+		if p.period, err = time.ParseDuration(opts["period"]); err != nil {
+			return nil, fmt.Errorf("invalid sine profile %q: %v", spec, err)
+		}
+		if p.max < p.min || p.period <= 0 {
+			return nil, fmt.Errorf("invalid sine profile %q: max must be at least min and period must be positive", spec)
+		}
+		return p, nil
+	case "replay":
+		stages, err := loadReplay(args)
+		if err != nil {
+			return nil, fmt.Errorf("invalid replay profile %q: %v", spec, err)
+		}
+		return replayProfile{stages: stages}, nil
+	default:
+		return nil, fmt.Errorf("unknown load profile %q: must be one of constant, ramp, step, spike, sine or replay", kind)
+	}
+}
+
+// parses `DURATION@RATE,DURATION@RATE,...`
+func parseStages(spec string) ([]stage, error) {
+	var stages []stage
+	for _, s := range strings.Split(spec, ",") {
+		d, r, ok := strings.Cut(strings.TrimSpace(s), "@")
+		if !ok {
+			return nil, fmt.Errorf("stage %q must be DURATION@RATE", s)
+		}
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			return nil, err
+		}
+		if duration <= 0 {
+			return nil, fmt.Errorf("stage %q must have a positive duration", s)
+		}
+		rate, err := parseRate(r)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage{duration: duration, rate: rate})
+	}
+	return stages, nil
+}
+
+// parses `key=value,key=value`, every key is required
+func parseOptions(spec string, keys ...string) (map[string]string, error) {
+	opts := map[string]string{}
+	for _, kv := range strings.Split(spec, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(kv), "=")
+		if !ok {
+			return nil, fmt.Errorf("option %q must be key=value", kv)
+		}
+		opts[k] = v
+	}
+	for _, k := range keys {
+		if _, ok := opts[k]; !ok {
+			return nil, fmt.Errorf("missing option %s", k)
+		}
+	}
+	return opts, nil
+}
+
+func parseRate(s string) (float64, error) {
+	rate, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+		return 0, fmt.Errorf("invalid rate %q: must be a non-negative number", s)
+	}
+	return rate, nil
+}
+
+// loads a CSV time series of `offset,rate` rows, offsets are seconds or durations
+// since the start and an optional header row is skipped
+func loadReplay(path string) ([]stage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 {
+		if _, err := parseOffset(records[0][0]); err != nil {
+			records = records[1:]
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no rows in %s", path)
+	}
+
+	var (
+		stages []stage
+		last   time.Duration
+		rate   float64
+	)
+	for _, record := range records {
+		offset, err := parseOffset(record[0])
+		if err != nil {
+			return nil, err
+		}
+		if offset < last {
+			return nil, fmt.Errorf("offset %v is before the previous offset %v", record[0], last)
+		}
+		if offset > last {
+			// no requests are sent before the first point
+			stages = append(stages, stage{duration: offset - last, rate: rate})
+		}
+		if rate, err = parseRate(record[1]); err != nil {
+			return nil, err
+		}
+		last = offset
+	}
+	// hold the last point for a second so it is replayed too
+	return append(stages, stage{duration: time.Second, rate: rate}), nil
+}
+
+func parseOffset(s string) (time.Duration, error) {
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+func (p constantProfile) At(elapsed time.Duration) (float64, string, bool) {
+	return p.rate, fmt.Sprintf("constant (%v rps)", p.rate), false
+}
+
+func (p rampProfile) At(elapsed time.Duration) (float64, string, bool) {
+	from := p.start
+	for i, s := range p.stages {
+		if elapsed < s.duration {
+			rate := from + (s.rate-from)*float64(elapsed)/float64(s.duration)
+			return rate, fmt.Sprintf("%d/%d (ramp from %v to %v rps over %v)", i+1, len(p.stages), from, s.rate, s.duration), false
+		}
+		elapsed -= s.duration
+		from = s.rate
+	}
+	return from, "", true
+}
+
+func (p stepProfile) At(elapsed time.Duration) (float64, string, bool) {
+	return holdStages(p.stages, elapsed, "step")
+}
+
+func (p replayProfile) At(elapsed time.Duration) (float64, string, bool) {
+	return holdStages(p.stages, elapsed, "point")
+}
+
+func holdStages(stages []stage, elapsed time.Duration, name string) (float64, string, bool) {
+	for i, s := range stages {
+		if elapsed < s.duration {
+			return s.rate, fmt.Sprintf("%s %d/%d (%v rps for %v)", name, i+1, len(stages), s.rate, s.duration), false
+		}
+		elapsed -= s.duration
+	}
+	return 0, "", true
+}
+
+func (p spikeProfile) At(elapsed time.Duration) (float64, string, bool) {
+	if elapsed%p.every >= p.every-p.length {
+		return p.peak, fmt.Sprintf("spike (%v rps for %v)", p.peak, p.length), false
+	}
+	return p.base, fmt.Sprintf("base (%v rps for %v)", p.base, p.every-p.length), false
+}
+
+func (p sineProfile) At(elapsed time.Duration) (float64, string, bool) {
+	phase := 2 * math.Pi * float64(elapsed%p.period) / float64(p.period)
+	rate := p.min + (p.max-p.min)*(1-math.Cos(phase))/2
+	if phase < math.Pi {
+		return rate, fmt.Sprintf("rising (%v to %v rps)", p.min, p.max), false
+	}
+	return rate, fmt.Sprintf("falling (%v to %v rps)", p.max, p.min), false
+}
+
+// scaleProfile returns a profile sending scale times the rate of profile
+func scaleProfile(profile loadProfile, scale float64) loadProfile {
+	if scale == 1 {
+		return profile
+	}
+	if p, ok := profile.(constantProfile); ok {
+		return constantProfile{rate: p.rate * scale}
+	}
+	return scaledProfile{loadProfile: profile, scale: scale}
+}
+
+func (p scaledProfile) At(elapsed time.Duration) (float64, string, bool) {
+	rate, stage, done := p.loadProfile.At(elapsed)
+	return rate * p.scale, stage, done
+}
+
+func newRateController(limiter *timerate.Limiter) *rateController {
+	c := &rateController{limiter: limiter}
+	atomic.StoreUint64(&c.rate, math.Float64bits(float64(limiter.Limit())))
+	return c
+}
+
+// Set changes the rate, a rate of 0 pauses requests
+func (c *rateController) Set(rate float64) {
+	if rate == c.Rate() {
+		return
+	}
+	atomic.StoreUint64(&c.rate, math.Float64bits(rate))
+	if rate > 0 {
+		// the limiter follows the rate for users of the client's Ratelimiter, pauses are handled in Wait
+		c.limiter.SetLimit(timerate.Limit(rate))
+	}
+}
+
+func (c *rateController) Rate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&c.rate))
+}
+
+// Pause stops requests until Resume is called, independently of the rate
+func (c *rateController) Pause() {
+	atomic.StoreInt32(&c.paused, 1)
+}
+
+func (c *rateController) Resume() {
+	atomic.StoreInt32(&c.paused, 0)
+}
+
+func (c *rateController) Paused() bool {
+	return atomic.LoadInt32(&c.paused) == 1
+}
+
+// Wait blocks until the next request may be sent, honoring pauses and the rate, and
+// returns the time the request was intended to be sent at. Requests are scheduled at
+// fixed times, so a worker that fell behind, ex waiting on a slow response, sends the
+// requests it owes without waiting and their intended times show how late they are.
+// The wait is re-planned every profileTick from the current rate, so a load profile
+// raising the rate takes effect before a request scheduled at the lower rate is due.
+func (c *rateController) Wait(ctx context.Context) (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if err := ctx.Err(); err != nil {
+			return time.Time{}, err
+		}
+		rate := c.Rate()
+		if rate <= 0 || c.Paused() {
+			// requests are not owed for the time the worker was paused
+			c.next = time.Time{}
+			if !sleepUntil(ctx, time.Now().Add(profileTick)) {
+				return time.Time{}, ctx.Err()
+			}
+			continue
+		}
+		now := time.Now()
+		if c.next.IsZero() {
+			c.next, c.planned = now, rate
+		}
+		if rate != c.planned {
+			// the rest of the wait for the pending request is scaled to the new rate
+			if remaining := c.next.Sub(now); remaining > 0 {
+				c.next = now.Add(time.Duration(float64(remaining) * c.planned / rate))
+			}
+			c.planned = rate
+		}
+		if wait := c.next.Sub(now); wait > 0 {
+			if wait > profileTick {
+				wait = profileTick
+			}
+			if !sleepUntil(ctx, now.Add(wait)) {
+				return time.Time{}, ctx.Err()
+			}
+			continue
+		}
+		at := c.next
+		interval := 1 / rate
+		if c.poisson {
+			interval *= rand.ExpFloat64()
+		}
+		c.next = at.Add(time.Duration(interval * float64(time.Second)))
+		return at, nil
+	}
+}
+
+// runProfile updates the rate, or number of virtual users, as the profile progresses,
+// logging every stage change, and returns once the profile is over or ctx is done
+func runProfile(ctx context.Context, profile loadProfile, rc interface{ Set(float64) }, logger *log.Logger) {
+	start := time.Now()
+	ticker := time.NewTicker(profileTick)
+	defer ticker.Stop()
+	current := ""
+	for {
+		rate, stage, done := profile.At(time.Since(start))
+		if done {
+			logger.Println("Load profile complete")
+			return
+		}
+		if stage != current {
+			logger.Printf("Load profile stage %v", stage)
+			current = stage
+		}
+		rc.Set(rate)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sleepUntil waits until t, returning false if ctx is done first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	timerate "golang.org/x/time/rate"
+)
+
+func Test_parseProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantErr bool
+	}{
+		{name: "default", spec: ""},
+		{name: "constant", spec: "constant"},
+		{name: "ramp", spec: "ramp:1m@100,5m@100,1m@0"},
+		{name: "step", spec: "step:30s@10, 30s@50"},
+		{name: "spike", spec: "spike:peak=200,length=30s,every=5m"},
+		{name: "sine", spec: "sine:min=10,max=100,period=24h"},
+		{name: "unknown", spec: "square:1m@10", wantErr: true},
+		{name: "bad stage", spec: "ramp:1m", wantErr: true},
+		{name: "negative rate", spec: "step:1m@-1", wantErr: true},
+		{name: "zero duration", spec: "step:0s@1", wantErr: true},
+		{name: "missing option", spec: "spike:peak=200,length=30s", wantErr: true},
+		{name: "spike longer than period", spec: "spike:peak=200,length=5m,every=1m", wantErr: true},
+		{name: "sine max below min", spec: "sine:min=10,max=1,period=1h", wantErr: true},
+		{name: "missing replay file", spec: "replay:/does/not/exist.csv", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseProfile(tt.spec, 1); (err != nil) != tt.wantErr {
+				t.Errorf("parseProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_loadProfile_At(t *testing.T) {
+	replay := filepath.Join(t.TempDir(), "rps.csv")
+	if err := os.WriteFile(replay, []byte("offset,rps\n0,5\n10,20\n1m,0\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		spec     string
+		elapsed  time.Duration
+		want     float64
+		wantDone bool
+	}{
+		{name: "constant", spec: "constant", elapsed: time.Hour, want: 10},
+		{name: "ramp start", spec: "ramp:10s@110", elapsed: 0, want: 10},
+		{name: "ramp middle", spec: "ramp:10s@110", elapsed: 5 * time.Second, want: 60},
+		{name: "ramp down", spec: "ramp:10s@110,10s@10", elapsed: 15 * time.Second, want: 60},
+		{name: "ramp done", spec: "ramp:10s@110", elapsed: 10 * time.Second, wantDone: true},
+		{name: "step first", spec: "step:10s@1,10s@2", elapsed: 9 * time.Second, want: 1},
+		{name: "step second", spec: "step:10s@1,10s@2", elapsed: 10 * time.Second, want: 2},
+		{name: "step done", spec: "step:10s@1,10s@2", elapsed: 20 * time.Second, wantDone: true},
+		{name: "spike base", spec: "spike:peak=100,length=10s,every=1m", elapsed: 49 * time.Second, want: 10},
+		{name: "spike peak", spec: "spike:peak=100,length=10s,every=1m", elapsed: 50 * time.Second, want: 100},
+		{name: "spike repeats", spec: "spike:peak=100,length=10s,every=1m", elapsed: 61 * time.Second, want: 10},
+		{name: "sine min", spec: "sine:min=10,max=30,period=1m", elapsed: 0, want: 10},
+		{name: "sine max", spec: "sine:min=10,max=30,period=1m", elapsed: 30 * time.Second, want: 30},
+		{name: "sine middle", spec: "sine:min=10,max=30,period=1m", elapsed: 15 * time.Second, want: 20},
+		{name: "replay first", spec: "replay:" + replay, elapsed: 5 * time.Second, want: 5},
+		{name: "replay second", spec: "replay:" + replay, elapsed: 30 * time.Second, want: 20},
+		{name: "replay last", spec: "replay:" + replay, elapsed: 60 * time.Second, want: 0},
+		{name: "replay done", spec: "replay:" + replay, elapsed: 61 * time.Second, wantDone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := parseProfile(tt.spec, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _, done := profile.At(tt.elapsed)
+			if done != tt.wantDone {
+				t.Fatalf("At() done = %v, want %v", done, tt.wantDone)
+			}
+			if !done && math.Abs(got-tt.want) > 0.001 {
+				t.Errorf("At() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_scaleProfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		scale   float64
+		elapsed time.Duration
+		want    float64
+	}{
+		{name: "unscaled", spec: "constant", scale: 1, want: 10},
+		{name: "constant", spec: "constant", scale: 0.25, want: 2.5},
+		{name: "ramp", spec: "ramp:10s@110", scale: 0.5, elapsed: 5 * time.Second, want: 30},
+		{name: "step", spec: "step:10s@1,10s@2", scale: 0.5, elapsed: 10 * time.Second, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile, err := parseProfile(tt.spec, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, _, _ := scaleProfile(profile, tt.scale).At(tt.elapsed); math.Abs(got-tt.want) > 0.001 {
+				t.Errorf("scaleProfile().At() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rateController(t *testing.T) {
+	rc := newRateController(timerate.NewLimiter(timerate.Limit(1000), 1))
+	rc.Set(0)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*profileTick)
+	defer cancel()
+	if _, err := rc.Wait(ctx); err == nil {
+		t.Fatalf("rateController.Wait() while paused error = nil, want deadline exceeded")
+	}
+
+	rc.Set(1000)
+	if _, err := rc.Wait(context.Background()); err != nil {
+		t.Errorf("rateController.Wait() after resume error = %v", err)
+	}
+	if got := float64(rc.limiter.Limit()); got != 1000 {
+		t.Errorf("limiter limit = %v, want 1000", got)
+	}
+}
+
+func Test_rateController_raise(t *testing.T) {
+	tests := []struct {
+		name string
+		from float64
+	}{
+		{name: "slow rate", from: 0.1},
+		{name: "paused", from: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := newRateController(timerate.NewLimiter(timerate.Limit(1), 1))
+			rc.Set(tt.from)
+			if tt.from > 0 {
+				// the next request is planned 10s out at the slow rate
+				if _, err := rc.Wait(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+			time.AfterFunc(50*time.Millisecond, func() { rc.Set(100) })
+			start := time.Now()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			for i := 0; i < 3; i++ {
+				if _, err := rc.Wait(ctx); err != nil {
+					t.Fatalf("rateController.Wait() after raising the rate error = %v", err)
+				}
+			}
+			if elapsed := time.Since(start); elapsed > 50*time.Millisecond+3*profileTick {
+				t.Errorf("rateController.Wait() after raising the rate took %v, want the new rate within a tick", elapsed)
+			}
+		})
+	}
+}
+
+func Test_rateController_intended(t *testing.T) {
+	rc := newRateController(timerate.NewLimiter(timerate.Limit(100), 1))
+	first, err := rc.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a worker stalled for 5 intervals owes 5 requests, sent without waiting
+	time.Sleep(50 * time.Millisecond)
+	start := time.Now()
+	for i := 1; i <= 5; i++ {
+		intended, err := rc.Wait(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := first.Add(time.Duration(i) * 10 * time.Millisecond); !intended.Equal(want) {
+			t.Errorf("rateController.Wait() = %v after the first request, want %v", intended.Sub(first), want.Sub(first))
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("rateController.Wait() for owed requests took %v, want no wait", elapsed)
+	}
+
+	// requests are not owed for pauses
+	rc.Pause()
+	time.AfterFunc(50*time.Millisecond, rc.Resume)
+	before := time.Now()
+	intended, err := rc.Wait(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if intended.Before(before) {
+		t.Errorf("rateController.Wait() after a pause = %v before resuming, want no requests owed", before.Sub(intended))
+	}
+}
@@ -0,0 +1,133 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// bodies are recorded up to this size, the rest is still passed on to handlers
+const maxRecordedBody = 1 << 20 // 1 MB
+
+type (
+	// recordedRequest is a request received by the server, one per line of a recording
+	recordedRequest struct {
+		Time   time.Time   `json:"time"`
+		Method string      `json:"method"`
+		URI    string      `json:"uri"`
+		Host   string      `json:"host"`
+		Header http.Header `json:"header"`
+		Body   string      `json:"body,omitempty"`
+		// Base64 is set for bodies that are not valid UTF-8
+		Base64    bool `json:"base64,omitempty"`
+		Truncated bool `json:"truncated,omitempty"`
+	}
+	// recorder writes the requests received by the server to an NDJSON file
+	recorder struct {
+		mu    sync.Mutex
+		file  *os.File
+		enc   *json.Encoder
+		count int
+		err   error
+	}
+)
+
+func newRecorder(path string) (*recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &recorder{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+// Record writes a request, keeping the first error
+func (rec *recorder) Record(req recordedRequest) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if rec.err != nil {
+		return
+	}
+	if rec.err = rec.enc.Encode(req); rec.err == nil {
+		rec.count++
+	}
+}
+
+// Close closes the file, returning the number of requests recorded and the first error
+func (rec *recorder) Close() (int, error) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if err := rec.file.Close(); rec.err == nil {
+		rec.err = err
+	}
+	return rec.count, rec.err
+}
+
+// recordRequests records every request before passing it on to next
+func recordRequests(rec *recorder) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req := recordedRequest{
+				Time:   time.Now(),
+				Method: r.Method,
+				URI:    r.URL.RequestURI(),
+				Host:   r.Host,
+				Header: r.Header.Clone(),
+			}
+			if r.Body != nil && r.Body != http.NoBody {
+				body, _ := io.ReadAll(io.LimitReader(r.Body, maxRecordedBody+1))
+				if len(body) > maxRecordedBody {
+					req.Truncated = true
+				}
+				// handlers read the recorded part of the body, then the rest
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+				if req.Truncated {
+					body = body[:maxRecordedBody]
+				}
+				req.Body = string(body)
+				if !utf8.Valid(body) {
+					req.Body, req.Base64 = base64.StdEncoding.EncodeToString(body), true
+				}
+			}
+			rec.Record(req)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// body returns the decoded body of a recorded request
+func (req recordedRequest) body() ([]byte, error) {
+	if req.Base64 {
+		return base64.StdEncoding.DecodeString(req.Body)
+	}
+	return []byte(req.Body), nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func Test_recordRequests(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "requests.ndjson")
+	rec, err := newRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received [][]byte
+	handler := recordRequests(rec)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, body)
+	}))
+	bodies := [][]byte{[]byte(`{"sku":"A1"}`), {0, 1, 0xff}, nil}
+	for _, body := range bodies {
+		req := httptest.NewRequest(http.MethodPost, "/api/orders?id=1", bytes.NewReader(body))
+		req.Header.Set("X-Test", "a")
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if count, err := rec.Close(); count != 3 || err != nil {
+		t.Fatalf("recorder.Close() = %v, %v, want 3 requests", count, err)
+	}
+
+	recorded, err := loadRecording(path)
+	if err != nil {
+		t.Fatalf("loadRecording() error = %v", err)
+	}
+	if len(recorded) != 3 {
+		t.Fatalf("loadRecording() = %d requests, want 3", len(recorded))
+	}
+	for i, req := range recorded {
+		if !bytes.Equal(received[i], bodies[i]) {
+			t.Errorf("handler read body %q, want %q", received[i], bodies[i])
+		}
+		body, err := req.body()
+		if err != nil || !bytes.Equal(body, bodies[i]) {
+			t.Errorf("recordedRequest.body() = %q, %v, want %q", body, err, bodies[i])
+		}
+		if req.Method != http.MethodPost || req.URI != "/api/orders?id=1" || req.Header.Get("X-Test") != "a" {
+			t.Errorf("recorded request = %+v", req)
+		}
+	}
+	if !recorded[1].Base64 || recorded[0].Base64 {
+		t.Errorf("recorded bodies base64 = %v, %v, want only the binary body encoded", recorded[0].Base64, recorded[1].Base64)
+	}
+}
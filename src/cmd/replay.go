@@ -0,0 +1,215 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// errReplayDone is returned by a replay after sending every request
+var errReplayDone = errors.New("replay done")
+
+// headers of recorded requests that are not replayed, they describe the
+// original connection or are set again by the worker
+var skippedHeaders = map[string]bool{
+	"Connection":          true,
+	"Content-Length":      true,
+	"Host":                true,
+	"Keep-Alive":          true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"X-Request-Id":        true,
+}
+
+type (
+	// replay sends requests at their offsets from the start of a recording, divided by
+	// speed, or as fast as possible with a speed of 0. Pauses delay the rest of the replay.
+	replay struct {
+		requests []replayedRequest
+		speed    float64
+		next     int
+		start    time.Time
+	}
+	replayedRequest struct {
+		offset time.Duration
+		target *Target
+	}
+)
+
+// loadRecording reads the requests of a server's --record file
+func loadRecording(path string) ([]recordedRequest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var requests []recordedRequest
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 4*maxRecordedBody)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var req recordedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return nil, fmt.Errorf("invalid recording %s line %d: %v", path, line, err)
+		}
+		requests = append(requests, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read recording %s: %v", path, err)
+	}
+	if len(requests) == 0 {
+		return nil, fmt.Errorf("recording %s has no requests", path)
+	}
+	return requests, nil
+}
+
+// newReplay replays recorded requests against base, keeping their paths and queries under
+// its path. Headers of defaults override recorded ones, and replayed requests share the
+// stats, checks, auth and circuit breaker of base.
+func newReplay(recorded []recordedRequest, base *Target, defaults *Target, speed float64) (*replay, error) {
+	if base.u == nil {
+		return nil, fmt.Errorf("unable to replay against %s: templated URLs are not supported", base.URL)
+	}
+	sort.SliceStable(recorded, func(i, j int) bool { return recorded[i].Time.Before(recorded[j].Time) })
+	r := &replay{speed: speed}
+	for _, rec := range recorded {
+		uri, err := url.ParseRequestURI(rec.URI)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recorded request %v %v: %v", rec.Method, rec.URI, err)
+		}
+		u := *base.u
+		u.Path = strings.TrimSuffix(base.u.Path, "/") + uri.Path
+		u.RawPath = ""
+		u.RawQuery = uri.RawQuery
+		body, err := rec.body()
+		if err != nil {
+			return nil, fmt.Errorf("invalid body of recorded request %v %v: %v", rec.Method, rec.URI, err)
+		}
+		target, err := replayTarget(rec.Method, &u, rec.Header, body, defaults.Header, base.Check, base.auth, base.Name())
+		if err != nil {
+			return nil, err
+		}
+		r.requests = append(r.requests, replayedRequest{offset: rec.Time.Sub(recorded[0].Time), target: target})
+	}
+	return r, nil
+}
+
+// replayTarget is the target sending a replayed request to u. Headers are sent as they
+// were, except for skippedHeaders, and defaults override them. auth, when set, replaces the recorded credentials.
+func replayTarget(method string, u *url.URL, replayed http.Header, body []byte, defaults map[string]string, check Checks, auth authenticator, name string) (*Target, error) {
+	header := map[string]string{}
+	for k, v := range replayed {
+		k = http.CanonicalHeaderKey(k)
+		switch {
+		case skippedHeaders[k]:
+		case k == "Cookie":
+			header[k] = literal(strings.Join(v, "; "))
+		default:
+			header[k] = literal(strings.Join(v, ", "))
+		}
+	}
+	for k, v := range defaults {
+		header[http.CanonicalHeaderKey(k)] = v
+	}
+	target := &Target{
+		URL:    u.String(),
+		Weight: 1,
+		Method: method,
+		Header: header,
+		Body:   string(body),
+		Check:  check,
+		u:      u,
+		name:   name,
+		auth:   auth,
+	}
+	if err := target.compile(); err != nil {
+		return nil, err
+	}
+	return target, nil
+}
+
+// Names are the distinct stats names of the replayed requests
+func (r *replay) Names() []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, req := range r.requests {
+		if name := req.target.Name(); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Next waits until the next request is due and returns it with the time it was intended
+// to be sent at, pacer is checked for pauses. It returns errReplayDone after the last request.
+func (r *replay) Next(ctx context.Context, pacer *rateController) (time.Time, *Target, error) {
+	if r.next == len(r.requests) {
+		return time.Time{}, nil, errReplayDone
+	}
+	for pacer != nil && pacer.Paused() {
+		paused := time.Now()
+		if !sleepUntil(ctx, paused.Add(profileTick)) {
+			return time.Time{}, nil, ctx.Err()
+		}
+		if !r.start.IsZero() {
+			r.start = r.start.Add(time.Since(paused))
+		}
+	}
+	if r.start.IsZero() {
+		r.start = time.Now()
+	}
+	req := r.requests[r.next]
+	at := time.Now()
+	if r.speed > 0 {
+		at = r.start.Add(time.Duration(float64(req.offset) / r.speed))
+		if !sleepUntil(ctx, at) {
+			return time.Time{}, nil, ctx.Err()
+		}
+	}
+	r.next++
+	return at, req.target, nil
+}
+
+// Duration is how long the replay takes at its speed
+func (r *replay) Duration() time.Duration {
+	if r.speed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(r.requests[len(r.requests)-1].offset) / r.speed)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_newReplay(t *testing.T) {
+	base, err := parseTarget("http://new:8080/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	recorded := []recordedRequest{
+		{Time: start.Add(time.Second), Method: http.MethodPost, URI: "/orders", Body: `{"sku":"A1"}`,
+			Header: http.Header{"Content-Length": {"12"}, "Authorization": {"Bearer old"}}},
+		{Time: start, Method: http.MethodGet, URI: "/items?id=1&q=a%20b", Host: "old:80",
+			Header: http.Header{"Accept": {"text/html", "*/*"}, "X-Tpl": {"{{ uuid }}"}, "X-Request-Id": {"abc"}}},
+	}
+	defaults := &Target{Header: map[string]string{"Authorization": "Bearer {{ \"new\" }}"}}
+	r, err := newReplay(recorded, base, defaults, 1)
+	if err != nil {
+		t.Fatalf("newReplay() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		i          int
+		wantURL    string
+		wantOffset time.Duration
+		wantHeader map[string]string
+		wantBody   string
+	}{
+		{name: "sorted by time", i: 0, wantURL: "http://new:8080/v2/items?id=1&q=a%20b",
+			wantHeader: map[string]string{"Accept": "text/html, */*", "X-Tpl": "{{ uuid }}", "X-Request-Id": "", "Authorization": "Bearer new"}},
+		{name: "body", i: 1, wantURL: "http://new:8080/v2/orders", wantOffset: time.Second, wantBody: `{"sku":"A1"}`,
+			wantHeader: map[string]string{"Content-Length": "", "Authorization": "Bearer new"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replayed := r.requests[tt.i]
+			if replayed.offset != tt.wantOffset || replayed.target.Name() != base.Name() {
+				t.Errorf("replayed request offset = %v, name = %v, want %v, %v", replayed.offset, replayed.target.Name(), tt.wantOffset, base.Name())
+			}
+			req, err := replayed.target.newRequest(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if req.URL.String() != tt.wantURL {
+				t.Errorf("URL = %v, want %v", req.URL, tt.wantURL)
+			}
+			for k, want := range tt.wantHeader {
+				if got := req.Header.Get(k); got != want {
+					t.Errorf("header %v = %q, want %q", k, got, want)
+				}
+			}
+			body := ""
+			if req.Body != nil {
+				b, _ := io.ReadAll(req.Body)
+				body = string(b)
+			}
+			if body != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func Test_replay_Next(t *testing.T) {
+	target, _ := parseTarget("http://a/")
+	tests := []struct {
+		name  string
+		speed float64
+		min   time.Duration
+		max   time.Duration
+	}{
+		{name: "original pace", speed: 1, min: 100 * time.Millisecond, max: 150 * time.Millisecond},
+		{name: "twice as fast", speed: 2, min: 50 * time.Millisecond, max: 90 * time.Millisecond},
+		{name: "as fast as possible", speed: 0, max: 20 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &replay{speed: tt.speed, requests: []replayedRequest{
+				{target: target},
+				{offset: 50 * time.Millisecond, target: target},
+				{offset: 100 * time.Millisecond, target: target},
+			}}
+			start := time.Now()
+			for range r.requests {
+				if _, got, err := r.Next(context.Background(), nil); err != nil || got != target {
+					t.Fatalf("replay.Next() = %v, %v, want the target", got, err)
+				}
+			}
+			if elapsed := time.Since(start); elapsed < tt.min || elapsed > tt.max {
+				t.Errorf("replay took %v, want between %v and %v", elapsed, tt.min, tt.max)
+			}
+			if _, _, err := r.Next(context.Background(), nil); err != errReplayDone {
+				t.Errorf("replay.Next() after the last request error = %v, want %v", err, errReplayDone)
+			}
+		})
+	}
+}
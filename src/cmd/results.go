@@ -0,0 +1,378 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	outSummary  = "summary"
+	outRequests = "requests"
+)
+
+type (
+	// resultsFile exports the worker's results as JSON or CSV, either a record per request as
+	// requests complete or the summary of every target when the worker stops
+	resultsFile struct {
+		mu       sync.Mutex
+		file     *os.File
+		w        *bufio.Writer
+		csv      *csv.Writer
+		json     bool
+		requests bool
+		start    time.Time
+		records  int
+	}
+	// resultRecord is the exported form of a single request
+	resultRecord struct {
+		Timestamp time.Time `json:"timestamp"`
+		Intended  time.Time `json:"intended"`
+		Target    string    `json:"target"`
+		Method    string    `json:"method"`
+		URL       string    `json:"url"`
+		Status    int       `json:"status"`
+		LatencyMs float64   `json:"latency_ms"`
+		// CorrectedMs is the response time from the intended send time
+		CorrectedMs float64  `json:"corrected_ms"`
+		ErrorClass  string   `json:"error_class,omitempty"`
+		Error       string   `json:"error,omitempty"`
+		Failed      []string `json:"failed_checks,omitempty"`
+		Attempts    int      `json:"attempts"`
+		RequestID   string   `json:"request_id"`
+	}
+	// summaryRecord is the exported form of a Summary
+	summaryRecord struct {
+		Target      string           `json:"target"`
+		Requests    int64            `json:"requests"`
+		Retries     int64            `json:"retries"`
+		ElapsedSecs float64          `json:"elapsed_s"`
+		RPS         float64          `json:"rps"`
+		Errors      int64            `json:"errors"`
+		ErrorClass  map[string]int64 `json:"error_classes"`
+		Failed      int64            `json:"failed"`
+		Checks      map[string]int64 `json:"failed_checks"`
+		Codes       map[int]int64    `json:"codes"`
+		LatencyMs   latencyRecord    `json:"latency_ms"`
+		CorrectedMs latencyRecord    `json:"corrected_latency_ms"`
+	}
+	latencyRecord struct {
+		P50  float64 `json:"p50"`
+		P90  float64 `json:"p90"`
+		P95  float64 `json:"p95"`
+		P99  float64 `json:"p99"`
+		P999 float64 `json:"p99.9"`
+		Max  float64 `json:"max"`
+		Mean float64 `json:"mean"`
+	}
+
+	// junitReport is a JUnit XML document with a test suite of the worker's results
+	junitReport struct {
+		XMLName  xml.Name     `xml:"testsuites"`
+		Name     string       `xml:"name,attr"`
+		Tests    int          `xml:"tests,attr"`
+		Failures int          `xml:"failures,attr"`
+		Time     string       `xml:"time,attr"`
+		Suites   []junitSuite `xml:"testsuite"`
+	}
+	junitSuite struct {
+		Name      string      `xml:"name,attr"`
+		Tests     int         `xml:"tests,attr"`
+		Failures  int         `xml:"failures,attr"`
+		Errors    int         `xml:"errors,attr"`
+		Time      string      `xml:"time,attr"`
+		Timestamp string      `xml:"timestamp,attr"`
+		Cases     []junitCase `xml:"testcase"`
+	}
+	junitCase struct {
+		Name      string        `xml:"name,attr"`
+		Classname string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+		SystemOut string        `xml:"system-out,omitempty"`
+	}
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+var (
+	requestColumns = []string{"timestamp", "target", "method", "url", "status", "latency_ms", "error_class", "error", "failed_checks", "attempts", "request_id", "intended", "corrected_ms"}
+	summaryColumns = []string{"target", "requests", "retries", "elapsed_s", "rps", "errors", "error_classes", "failed", "failed_checks", "codes",
+		"p50_ms", "p90_ms", "p95_ms", "p99_ms", "p99.9_ms", "max_ms", "mean_ms",
+		"corrected_p50_ms", "corrected_p90_ms", "corrected_p95_ms", "corrected_p99_ms", "corrected_p99.9_ms", "corrected_max_ms", "corrected_mean_ms"}
+)
+
+// openResults creates a .json or .csv results file, detail is summary or requests. Requests
+// completed before start, the end of the warm-up, are not exported.
+func openResults(path, detail string, start time.Time) (*resultsFile, error) {
+	rf := &resultsFile{requests: detail == outRequests, start: start}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		rf.json = true
+	case ".csv":
+	default:
+		return nil, fmt.Errorf("results file %v: unsupported format %q, want .json or .csv", path, ext)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	rf.file = file
+	rf.w = bufio.NewWriter(file)
+	if rf.json {
+		if rf.requests {
+			rf.w.WriteString("[")
+		}
+		return rf, nil
+	}
+	rf.csv = csv.NewWriter(rf.w)
+	if rf.requests {
+		rf.csv.Write(requestColumns)
+	} else {
+		rf.csv.Write(summaryColumns)
+	}
+	return rf, nil
+}
+
+// Record exports a completed request when writing per-request records
+func (rf *resultsFile) Record(req *Request) {
+	if !rf.requests || req.start.Before(rf.start) {
+		return
+	}
+	record := newResultRecord(req)
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	rf.records++
+	if rf.json {
+		if rf.records > 1 {
+			rf.w.WriteString(",")
+		}
+		rf.w.WriteString("\n  ")
+		line, _ := json.Marshal(record)
+		rf.w.Write(line)
+		return
+	}
+	rf.csv.Write([]string{
+		record.Timestamp.Format(time.RFC3339Nano),
+		record.Target,
+		record.Method,
+		record.URL,
+		strconv.Itoa(record.Status),
+		formatMillis(record.LatencyMs),
+		record.ErrorClass,
+		record.Error,
+		strings.Join(record.Failed, ";"),
+		strconv.Itoa(record.Attempts),
+		record.RequestID,
+		record.Intended.Format(time.RFC3339Nano),
+		formatMillis(record.CorrectedMs),
+	})
+}
+
+// Close writes the summaries, unless writing per-request records, and closes the file
+func (rf *resultsFile) Close(summaries []Summary) error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	switch {
+	case rf.json && rf.requests:
+		rf.w.WriteString("\n]\n")
+	case rf.json:
+		records := make([]summaryRecord, 0, len(summaries))
+		for _, summary := range summaries {
+			records = append(records, newSummaryRecord(summary))
+		}
+		out, err := json.MarshalIndent(records, "", "  ")
+		if err != nil {
+			rf.file.Close()
+			return err
+		}
+		rf.w.Write(append(out, '\n'))
+	case !rf.requests:
+		for _, summary := range summaries {
+			record := newSummaryRecord(summary)
+			l, c := record.LatencyMs, record.CorrectedMs
+			rf.csv.Write([]string{
+				record.Target,
+				strconv.FormatInt(record.Requests, 10),
+				strconv.FormatInt(record.Retries, 10),
+				strconv.FormatFloat(record.ElapsedSecs, 'f', 3, 64),
+				strconv.FormatFloat(record.RPS, 'f', 3, 64),
+				strconv.FormatInt(record.Errors, 10),
+				strings.Trim(formatErrors(record.ErrorClass), "()"),
+				strconv.FormatInt(record.Failed, 10),
+				strings.Trim(formatErrors(record.Checks), "()"),
+				formatCodes(record.Codes),
+				formatMillis(l.P50), formatMillis(l.P90), formatMillis(l.P95), formatMillis(l.P99),
+				formatMillis(l.P999), formatMillis(l.Max), formatMillis(l.Mean),
+				formatMillis(c.P50), formatMillis(c.P90), formatMillis(c.P95), formatMillis(c.P99),
+				formatMillis(c.P999), formatMillis(c.Max), formatMillis(c.Mean),
+			})
+		}
+	}
+	if rf.csv != nil {
+		rf.csv.Flush()
+		if err := rf.csv.Error(); err != nil {
+			rf.file.Close()
+			return err
+		}
+	}
+	if err := rf.w.Flush(); err != nil {
+		rf.file.Close()
+		return err
+	}
+	return rf.file.Close()
+}
+
+func newResultRecord(req *Request) resultRecord {
+	record := resultRecord{
+		Timestamp:   req.start,
+		Intended:    req.intended,
+		Target:      req.target.Name(),
+		LatencyMs:   millis(req.latency),
+		CorrectedMs: millis(req.responseTime()),
+		Attempts:    req.attempts,
+		RequestID:   req.id,
+	}
+	if req.intended.IsZero() {
+		record.Intended = req.start
+	}
+	if req.R != nil {
+		record.Method = req.R.Method
+		record.URL = req.R.URL.String()
+		if id := req.R.Header.Get("X-Request-Id"); id != "" {
+			record.RequestID = id
+		}
+	}
+	if req.e != nil {
+		record.ErrorClass = errorClass(req.e)
+		record.Error = req.e.Error()
+	} else {
+		record.Status = req.r.StatusCode
+		record.Failed = req.failed
+	}
+	return record
+}
+
+func newSummaryRecord(s Summary) summaryRecord {
+	return summaryRecord{
+		Target:      s.Target,
+		Requests:    s.Requests,
+		Retries:     s.Retries,
+		ElapsedSecs: s.Elapsed.Seconds(),
+		RPS:         s.RPS(),
+		Errors:      s.ErrorCount(),
+		ErrorClass:  s.Errors,
+		Failed:      s.Failed,
+		Checks:      s.Checks,
+		Codes:       s.Codes,
+		LatencyMs:   newLatencyRecord(s.Latency),
+		CorrectedMs: newLatencyRecord(s.ResponseTime),
+	}
+}
+
+func newLatencyRecord(l Latency) latencyRecord {
+	return latencyRecord{
+		P50:  millis(l.P50),
+		P90:  millis(l.P90),
+		P95:  millis(l.P95),
+		P99:  millis(l.P99),
+		P999: millis(l.P999),
+		Max:  millis(l.Max),
+		Mean: millis(l.Mean),
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func formatMillis(ms float64) string {
+	return strconv.FormatFloat(ms, 'f', -1, 64)
+}
+
+// newJUnitReport returns a report with a test case per target, failing targets that never responded
+func newJUnitReport(summaries []Summary, started time.Time, elapsed time.Duration) *junitReport {
+	report := &junitReport{
+		Name: "example-app",
+		Suites: []junitSuite{{
+			Name:      "worker",
+			Timestamp: started.Format("2006-01-02T15:04:05"),
+		}},
+	}
+	for _, summary := range summaries {
+		tc := junitCase{
+			Name:      summary.Target,
+			Classname: "worker.targets",
+			Time:      junitSeconds(summary.Elapsed),
+			SystemOut: summary.String(),
+		}
+		if summary.Requests == summary.ErrorCount() {
+			tc.Failure = &junitFailure{
+				Message: "no responses from " + summary.Target,
+				Type:    "no_responses",
+				Text:    summary.String(),
+			}
+		}
+		report.add(tc)
+	}
+	report.Time = junitSeconds(elapsed)
+	report.Suites[0].Time = report.Time
+	return report
+}
+
+// add appends a test case to the worker suite
+func (r *junitReport) add(tc junitCase) {
+	suite := &r.Suites[0]
+	suite.Cases = append(suite.Cases, tc)
+	suite.Tests++
+	r.Tests++
+	if tc.Failure != nil {
+		suite.Failures++
+		r.Failures++
+	}
+}
+
+// Write saves the report to path
+func (r *junitReport) Write(path string) error {
+	out, err := xml.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(out, '\n')...), 0o644)
+}
+
+func junitSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func testRequests(t *testing.T) []*Request {
+	t.Helper()
+	target, _ := parseTarget("http://a/")
+	ok, _ := http.NewRequest(http.MethodGet, "http://a/", nil)
+	ok.Header.Set("X-Request-Id", "abc")
+	now := time.Now()
+	return []*Request{
+		{R: ok, r: &http.Response{StatusCode: 200}, id: "abc", target: target, start: now, intended: now.Add(-2 * time.Millisecond), latency: 1500 * time.Microsecond},
+		{e: syscall.ECONNREFUSED, id: "def", target: target, start: time.Now()},
+	}
+}
+
+func Test_resultsFile(t *testing.T) {
+	summaries := []Summary{{Target: "http://a/", Requests: 2, Errors: map[string]int64{"connection_refused": 1},
+		Codes: map[int]int64{200: 1}, Elapsed: time.Second, Latency: Latency{P50: time.Millisecond}}}
+	tests := []struct {
+		name   string
+		file   string
+		detail string
+		check  func(t *testing.T, out []byte)
+	}{
+		{name: "json requests", file: "results.json", detail: outRequests, check: func(t *testing.T, out []byte) {
+			var records []resultRecord
+			if err := json.Unmarshal(out, &records); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v\n%s", err, out)
+			}
+			if len(records) != 2 || records[0].Status != 200 || records[0].LatencyMs != 1.5 || records[0].CorrectedMs != 3.5 || records[0].RequestID != "abc" ||
+				records[1].ErrorClass != "connection_refused" || records[1].RequestID != "def" {
+				t.Errorf("records = %+v", records)
+			}
+		}},
+		{name: "json summary", file: "results.json", detail: outSummary, check: func(t *testing.T, out []byte) {
+			var records []summaryRecord
+			if err := json.Unmarshal(out, &records); err != nil {
+				t.Fatalf("json.Unmarshal() error = %v\n%s", err, out)
+			}
+			if len(records) != 1 || records[0].RPS != 2 || records[0].Errors != 1 || records[0].LatencyMs.P50 != 1 {
+				t.Errorf("records = %+v", records)
+			}
+		}},
+		{name: "csv requests", file: "results.csv", detail: outRequests, check: func(t *testing.T, out []byte) {
+			rows := readResultsCSV(t, out)
+			if len(rows) != 3 || rows[1][4] != "200" || rows[1][5] != "1.5" || rows[2][6] != "connection_refused" || rows[1][12] != "3.5" || rows[2][0] != rows[2][11] {
+				t.Errorf("rows = %v", rows)
+			}
+		}},
+		{name: "csv summary", file: "results.csv", detail: outSummary, check: func(t *testing.T, out []byte) {
+			rows := readResultsCSV(t, out)
+			if len(rows) != 2 || rows[1][0] != "http://a/" || rows[1][6] != "connection_refused:1" || rows[1][9] != "200:1" {
+				t.Errorf("rows = %v", rows)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			rf, err := openResults(path, tt.detail, time.Time{})
+			if err != nil {
+				t.Fatalf("openResults() error = %v", err)
+			}
+			for _, req := range testRequests(t) {
+				rf.Record(req)
+			}
+			if err := rf.Close(summaries); err != nil {
+				t.Fatalf("resultsFile.Close() error = %v", err)
+			}
+			out, _ := os.ReadFile(path)
+			tt.check(t, out)
+		})
+	}
+}
+
+func Test_openResults_format(t *testing.T) {
+	if _, err := openResults(filepath.Join(t.TempDir(), "results.txt"), outSummary, time.Time{}); err == nil {
+		t.Errorf("openResults() error = nil, want unsupported format")
+	}
+}
+
+func Test_newJUnitReport(t *testing.T) {
+	report := newJUnitReport([]Summary{
+		{Target: "http://a/", Requests: 2, Codes: map[int]int64{200: 2}, Elapsed: time.Second},
+		{Target: "http://b/", Requests: 1, Errors: map[string]int64{"dns": 1}, Elapsed: time.Second},
+	}, time.Now(), time.Second)
+	path := filepath.Join(t.TempDir(), "junit.xml")
+	if err := report.Write(path); err != nil {
+		t.Fatalf("junitReport.Write() error = %v", err)
+	}
+	out, _ := os.ReadFile(path)
+	var got junitReport
+	if err := xml.Unmarshal(out, &got); err != nil {
+		t.Fatalf("xml.Unmarshal() error = %v\n%s", err, out)
+	}
+	if got.Tests != 2 || got.Failures != 1 || len(got.Suites) != 1 || got.Suites[0].Cases[1].Failure == nil {
+		t.Errorf("newJUnitReport() = %+v, want 2 tests with http://b/ failing", got)
+	}
+}
+
+func readResultsCSV(t *testing.T, out []byte) [][]string {
+	t.Helper()
+	rows, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("csv.ReadAll() error = %v", err)
+	}
+	return rows
+}
@@ -0,0 +1,250 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	jitterNone  = "none"
+	jitterFull  = "full"
+	jitterEqual = "equal"
+
+	// bodies of retried responses are drained up to this size so their connection can be reused
+	maxDrainBody = 64 << 10 // 64 KB
+)
+
+// outcomeSet matches the outcome of an attempt by its status code or error class
+type outcomeSet struct {
+	statuses []statusRange
+	classes  map[string]bool
+}
+
+// retryPolicy decides which failed attempts at a request are retried and when. Delays grow
+// exponentially from backoff up to maxBackoff, with optional jitter, unless the response has
+// a Retry-After header. A budget greater than 0 caps retries to that percentage of requests.
+type retryPolicy struct {
+	outcomeSet
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	jitter      string
+	budget      float64
+
+	mu       sync.Mutex
+	requests int64
+	retries  int64
+}
+
+// newRetryPolicy returns a policy retrying the status codes, ranges and error classes of retryOn, ex 429,502-504,timeout
+func newRetryPolicy(maxAttempts int, retryOn string, backoff, maxBackoff time.Duration, jitter string, budget float64) (*retryPolicy, error) {
+	on, err := parseOutcomes(retryOn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid retry condition %v", err)
+	}
+	return &retryPolicy{
+		outcomeSet:  on,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		maxBackoff:  maxBackoff,
+		jitter:      jitter,
+		budget:      budget,
+	}, nil
+}
+
+// parseOutcomes parses a comma separated list of status codes, ranges and error classes
+func parseOutcomes(spec string) (outcomeSet, error) {
+	o := outcomeSet{classes: map[string]bool{}}
+	known := map[string]bool{}
+	for _, class := range errorClasses {
+		known[class] = true
+	}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		switch {
+		case field == "":
+		case known[field]:
+			o.classes[field] = true
+		default:
+			statuses, err := parseStatusRanges(field)
+			if err != nil {
+				return o, fmt.Errorf("%q: want a status code, range or one of %v", field, strings.Join(errorClasses, ", "))
+			}
+			o.statuses = append(o.statuses, statuses...)
+		}
+	}
+	return o, nil
+}
+
+// Match reports whether the outcome of an attempt is in the set
+func (o outcomeSet) Match(resp *http.Response, err error) bool {
+	if err != nil {
+		return o.classes[errorClass(err)]
+	}
+	return statusIn(resp.StatusCode, o.statuses)
+}
+
+// started counts a request towards the retry budget
+func (p *retryPolicy) started() {
+	p.mu.Lock()
+	p.requests++
+	p.mu.Unlock()
+}
+
+// allow takes a retry from the budget, returning false once it is exhausted
+func (p *retryPolicy) allow() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.budget > 0 && float64(p.retries+1) > float64(p.requests)*p.budget/100 {
+		return false
+	}
+	p.retries++
+	return true
+}
+
+// delay returns how long to wait before the next attempt, after attempt attempts
+func (p *retryPolicy) delay(attempt int, resp *http.Response) time.Duration {
+	if wait, ok := retryAfter(resp); ok {
+		if wait > p.maxBackoff {
+			return p.maxBackoff
+		}
+		return wait
+	}
+	wait := p.backoff
+	for i := 1; i < attempt && wait < p.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > p.maxBackoff {
+		wait = p.maxBackoff
+	}
+	switch p.jitter {
+	case jitterFull:
+		return time.Duration(rand.Int63n(int64(wait) + 1))
+	case jitterEqual:
+		return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	default:
+		return wait
+	}
+}
+
+// retryAfter parses the Retry-After header of a response, in seconds or as an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// httpClient returns the client sending req, with the cookie jar of its journey
+func (c *RLHTTPClient) httpClient(req *Request) *http.Client {
+	if req.jar == nil {
+		return c.client
+	}
+	client := *c.client
+	client.Jar = req.jar
+	return &client
+}
+
+// context of a request, the worker's if it was sent by a worker
+func (req *Request) context() context.Context {
+	if req.ctx != nil {
+		return req.ctx
+	}
+	return req.R.Context()
+}
+
+// send makes the attempts at a request allowed by the client's retry policy
+func (c *RLHTTPClient) send(req *Request, logger *log.Logger) {
+	if c.retry != nil {
+		c.retry.started()
+	}
+	for {
+		req.attempts++
+		req.r, req.e = c.httpClient(req).Do(req.R)
+		if req.target.auth != nil && req.r != nil && req.r.StatusCode == http.StatusUnauthorized {
+			req.target.auth.Invalidate(req.R)
+		}
+		if c.retry == nil || req.attempts >= c.retry.maxAttempts || !c.retry.Match(req.r, req.e) {
+			return
+		}
+		if !c.retry.allow() {
+			if c.stats != nil {
+				c.stats.RetryDenied()
+			}
+			return
+		}
+		wait := c.retry.delay(req.attempts, req.r)
+		outcome := ""
+		if req.e != nil {
+			outcome = errorClass(req.e)
+		} else {
+			outcome = strconv.Itoa(req.r.StatusCode)
+			drainBody(req.r)
+		}
+		logger.Printf("[%v][%v] -> [%v] attempt %d of %d failed, retrying in %v", req.R.Method, req.R.URL, outcome, req.attempts, c.retry.maxAttempts, wait)
+		if !sleepUntil(req.context(), time.Now().Add(wait)) {
+			// the worker is stopping, the failed attempt is the outcome
+			if req.r != nil {
+				req.r.Body = http.NoBody // drained for the retry
+			}
+			return
+		}
+		next := req.R.Clone(req.R.Context())
+		if req.R.GetBody != nil {
+			body, err := req.R.GetBody()
+			if err != nil {
+				req.r, req.e = nil, err
+				return
+			}
+			next.Body = body
+		}
+		if req.target.auth != nil {
+			if req.e = req.target.auth.Apply(req.context(), next); req.e != nil {
+				req.r = nil
+				return
+			}
+		}
+		req.R = next
+	}
+}
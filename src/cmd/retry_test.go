@@ -0,0 +1,180 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	timerate "golang.org/x/time/rate"
+)
+
+func Test_newRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name         string
+		retryOn      string
+		wantStatuses []statusRange
+		wantClasses  map[string]bool
+		wantErr      bool
+	}{
+		{name: "codes and classes", retryOn: "429, 502-504,timeout", wantStatuses: []statusRange{{429, 429}, {502, 504}}, wantClasses: map[string]bool{"timeout": true}},
+		{name: "unknown class", retryOn: "500,flaky", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newRetryPolicy(3, tt.retryOn, time.Millisecond, time.Second, jitterNone, 0)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newRetryPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(got.statuses, tt.wantStatuses) || !reflect.DeepEqual(got.classes, tt.wantClasses) {
+				t.Errorf("newRetryPolicy() = %v %v, want %v %v", got.statuses, got.classes, tt.wantStatuses, tt.wantClasses)
+			}
+			if got.Match(nil, syscall.ECONNREFUSED) {
+				t.Errorf("retryPolicy.Match() = true for an error class not in %v", tt.retryOn)
+			}
+		})
+	}
+}
+
+func Test_retryPolicy_delay(t *testing.T) {
+	p, _ := newRetryPolicy(10, "503", 100*time.Millisecond, time.Second, jitterNone, 0)
+	tests := []struct {
+		name    string
+		attempt int
+		header  string
+		want    time.Duration
+	}{
+		{name: "first retry", attempt: 1, want: 100 * time.Millisecond},
+		{name: "doubles", attempt: 3, want: 400 * time.Millisecond},
+		{name: "capped", attempt: 8, want: time.Second},
+		{name: "retry after seconds", attempt: 1, header: "0", want: 0},
+		{name: "retry after capped", attempt: 1, header: "30", want: time.Second},
+		{name: "invalid retry after", attempt: 2, header: "soon", want: 200 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: 503, Header: http.Header{}}
+			if tt.header != "" {
+				resp.Header.Set("Retry-After", tt.header)
+			}
+			if got := p.delay(tt.attempt, resp); got != tt.want {
+				t.Errorf("retryPolicy.delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	p.jitter = jitterEqual
+	for i := 0; i < 100; i++ {
+		if got := p.delay(2, nil); got < 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("retryPolicy.delay() with equal jitter = %v, want between 100ms and 200ms", got)
+		}
+	}
+}
+
+func Test_retryPolicy_allow(t *testing.T) {
+	p, _ := newRetryPolicy(3, "503", 0, 0, jitterNone, 10)
+	for i := 0; i < 50; i++ {
+		p.started()
+	}
+	allowed := 0
+	for i := 0; i < 50; i++ {
+		if p.allow() {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Errorf("retryPolicy.allow() allowed %v retries of 50 requests, want 5 with a 10%% budget", allowed)
+	}
+}
+
+func TestRLHTTPClient_send(t *testing.T) {
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	client := newClient(timerate.NewLimiter(timerate.Inf, 1))
+	client.retry, _ = newRetryPolicy(3, "503", time.Millisecond, 10*time.Millisecond, jitterFull, 0)
+	target := &Target{URL: srv.URL + "/", Weight: 1, Method: http.MethodPost, Body: "payload"}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.compile(); err != nil {
+		t.Fatal(err)
+	}
+	req := &Request{target: target}
+	req.R, _ = target.newRequest(nil)
+	client.send(req, log.New(io.Discard, "", 0))
+	if req.e != nil {
+		t.Fatalf("RLHTTPClient.send() error = %v", req.e)
+	}
+	req.r.Body.Close()
+	if req.attempts != 3 || req.r.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("RLHTTPClient.send() = %v after %v attempts, want 503 after 3", req.r.StatusCode, req.attempts)
+	}
+	if want := []string{"payload", "payload", "payload"}; !reflect.DeepEqual(bodies, want) {
+		t.Errorf("request bodies = %q, want %q", bodies, want)
+	}
+}
+
+func TestRLHTTPClient_send_canceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	client := newClient(timerate.NewLimiter(timerate.Inf, 1))
+	client.retry, _ = newRetryPolicy(3, "503", time.Minute, time.Minute, jitterNone, 0)
+	target := &Target{URL: srv.URL + "/", Weight: 1, Method: http.MethodGet}
+	if err := target.init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := target.compile(); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	req := &Request{target: target, ctx: ctx}
+	req.R, _ = target.newRequest(nil)
+	start := time.Now()
+	client.send(req, log.New(io.Discard, "", 0))
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("RLHTTPClient.send() took %v after the worker stopped, want the retry wait canceled", elapsed)
+	}
+	if req.e != nil || req.attempts != 1 || req.r.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("RLHTTPClient.send() = %v, %v after %v attempts, want the 503 of the first attempt", req.r, req.e, req.attempts)
+	}
+	if body, err := readBody(req.r); err != nil || len(body) != 0 {
+		t.Errorf("readBody() = %q, %v, want an empty body", body, err)
+	}
+}
@@ -29,8 +29,9 @@ import (
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use:  "example-app",
-	Long: `A lightweight golang webserver/client useful for testing.`,
+	Use:               "example-app",
+	Long:              `A lightweight golang webserver/client useful for testing.`,
+	PersistentPreRunE: initConfig,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -44,6 +45,7 @@ func Execute() {
 
 func init() {
 	rootCmd.PersistentFlags().BoolP("datadog", "D", false, "Enable DataDog trace collection")
+	rootCmd.PersistentFlags().StringP("config", "c", "", "config file (yaml, json or toml), flags and EXAMPLE_APP_* env vars take precedence")
 	// for instructions on which env vars to populate to configure DataDog
 	// see https://docs.datadoghq.com/tracing/trace_collection/library_config/go/
 }
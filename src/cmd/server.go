@@ -22,28 +22,51 @@ THE SOFTWARE.
 package cmd
 
 import (
+	"net/http"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
-	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
 )
 
 // serverCmd represents the server command
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Starts a server instance",
+	PreRunE: validateFlags(
+		checkPort("port"),
+		checkNonNegative("delay", "max-header-bytes", "max-body-bytes"),
+		checkPercent("fail", "health-fail"),
+		checkDuration("read-timeout", "read-header-timeout", "write-timeout", "idle-timeout"),
+	),
 	Run: func(cmd *cobra.Command, args []string) {
 		port, _ := cmd.Flags().GetInt("port")
 		delay, _ := cmd.Flags().GetInt("delay")
 		fail, _ := cmd.Flags().GetInt("fail")
 		failHealth, _ := cmd.Flags().GetInt("health-fail")
 		datadog, _ := cmd.Flags().GetBool("datadog")
+		readTimeout, _ := cmd.Flags().GetDuration("read-timeout")
+		readHeaderTimeout, _ := cmd.Flags().GetDuration("read-header-timeout")
+		writeTimeout, _ := cmd.Flags().GetDuration("write-timeout")
+		idleTimeout, _ := cmd.Flags().GetDuration("idle-timeout")
+		maxHeaderBytes, _ := cmd.Flags().GetInt("max-header-bytes")
+		maxBodyBytes, _ := cmd.Flags().GetInt64("max-body-bytes")
+		recordPath, _ := cmd.Flags().GetString("record")
 
 		server := &Server{
-			port: port,
-			name: "Server",
+			port:    port,
+			name:    "Server",
 			datadog: datadog,
+			limits: Limits{
+				ReadTimeout:       readTimeout,
+				ReadHeaderTimeout: readHeaderTimeout,
+				WriteTimeout:      writeTimeout,
+				IdleTimeout:       idleTimeout,
+				MaxHeaderBytes:    maxHeaderBytes,
+				MaxBodyBytes:      maxBodyBytes,
+			},
 		}
 
 		server.logger = server.NewLogger()
@@ -69,15 +92,35 @@ var serverCmd = &cobra.Command{
 			defer profiler.Stop()
 		}
 		server.logger.Printf("Starting %v on port :%v", server.name, server.port)
+		if writeTimeout > 0 && time.Duration(delay)*time.Millisecond > writeTimeout {
+			server.logger.Printf("WARNING: delay of %v exceeds write timeout of %v, delayed responses will be dropped", time.Duration(delay)*time.Millisecond, writeTimeout)
+		}
+		handler := index(delay, fail)
+		var rec *recorder
+		if recordPath != "" {
+			var err error
+			if rec, err = newRecorder(recordPath); err != nil {
+				server.logger.Fatalf("Unable to record requests: %v", err)
+			}
+			handler = recordRequests(rec)(handler)
+			server.logger.Printf("Recording requests to %v", recordPath)
+		}
 		if datadog {
-			server.router.Handle("/", datadogTraceMiddleware(server.router, index(delay, fail), os.Getenv("DD_SERVICE")))
-			server.router.Handle("/healthz", datadogTraceMiddleware(server.router, healthz(failHealth), os.Getenv("DD_SERVICE")))
+			server.router.Handle("/", datadogTraceMiddleware(server.router, handler, os.Getenv("DD_SERVICE")))
+			server.router.Handle("/healthz", datadogTraceMiddleware(server.router, healthz(failHealth, server.Healthy), os.Getenv("DD_SERVICE")))
 		} else {
-			server.router.Handle("/", index(delay, fail))
-			server.router.Handle("/healthz", healthz(failHealth))
+			server.router.Handle("/", handler)
+			server.router.Handle("/healthz", healthz(failHealth, server.Healthy))
 		}
-		
+
 		server.Serve()
+		if rec != nil {
+			count, err := rec.Close()
+			if err != nil {
+				server.logger.Fatalf("Unable to record requests to %v: %v", recordPath, err)
+			}
+			server.logger.Printf("Recorded %d requests to %v", count, recordPath)
+		}
 	},
 }
 
@@ -89,4 +132,11 @@ func init() {
 	serverCmd.Flags().IntP("port", "p", 8080, "port to listen on")
 	serverCmd.Flags().IntP("fail", "f", 0, "% of requests to fail, ex 10 = 10%")
 	serverCmd.Flags().IntP("health-fail", "F", 0, "% of requests to /healthz to fail, ex 10 = 10%")
+	serverCmd.Flags().Duration("read-timeout", defaultReadTimeout, "max duration for reading the entire request, 0 = no timeout")
+	serverCmd.Flags().Duration("read-header-timeout", defaultReadHeaderTimeout, "max duration for reading request headers, 0 = no timeout")
+	serverCmd.Flags().Duration("write-timeout", defaultWriteTimeout, "max duration before timing out writes of the response, 0 = no timeout")
+	serverCmd.Flags().Duration("idle-timeout", defaultIdleTimeout, "max duration to wait for the next request on a keep-alive connection, 0 = no timeout")
+	serverCmd.Flags().Int("max-header-bytes", http.DefaultMaxHeaderBytes, "max size of request headers in bytes")
+	serverCmd.Flags().Int64("max-body-bytes", defaultMaxBodyBytes, "max size of request bodies in bytes, 0 = unlimited")
+	serverCmd.Flags().String("record", "", "record requests, except to /healthz, to this NDJSON file for the worker's --replay")
 }
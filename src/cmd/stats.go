@@ -0,0 +1,549 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	hdrhistogram "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const (
+	// latencies are recorded in microseconds, from 1µs up to an hour with 3 significant digits
+	histogramMin    = 1
+	histogramMax    = int64(time.Hour / time.Microsecond)
+	histogramDigits = 3
+
+	totalTarget = "total"
+)
+
+type (
+	// Stats aggregates the results of the worker's requests per target
+	Stats struct {
+		mu      sync.Mutex
+		start   time.Time
+		targets map[string]*targetStats
+		order   []string
+
+		// open loop request pool, see dispatcher
+		poolSize int64
+		inFlight int64
+		dropped  int64
+		delayed  int64
+
+		// retries refused by the retry budget
+		retriesDenied int64
+
+		// journeys run to the end or aborted by a failed step
+		journeysCompleted int64
+		journeysAborted   int64
+	}
+	targetStats struct {
+		requests    int64
+		retries     int64
+		newConns    int64
+		reusedConns int64
+		failed      int64
+		checks      map[string]int64
+		errors      map[string]int64
+		codes       map[int]int64
+		latency     *hdrhistogram.Histogram
+		// response times from the intended send time, corrected for coordinated omission
+		responseTime *hdrhistogram.Histogram
+	}
+	// Summary is a point in time view of the stats of a target
+	Summary struct {
+		Target   string
+		Requests int64
+		Errors   map[string]int64
+		Codes    map[int]int64
+		Elapsed  time.Duration
+		// Retries counts the attempts made after the first one
+		Retries int64
+		// NewConns and ReusedConns count the requests sent over new and reused connections
+		NewConns    int64
+		ReusedConns int64
+		// Failed counts responses failing their target's checks, by kind in Checks
+		Failed int64
+		Checks map[string]int64
+		// Latency is the service time of requests, from sending them to their response.
+		// ResponseTime also counts the time requests waited past their intended send time,
+		// correcting for coordinated omission when the worker fell behind its schedule.
+		Latency      Latency
+		ResponseTime Latency
+	}
+	// Latency percentiles of a target's requests
+	Latency struct {
+		P50  time.Duration
+		P90  time.Duration
+		P95  time.Duration
+		P99  time.Duration
+		P999 time.Duration
+		Max  time.Duration
+		Mean time.Duration
+	}
+	// StatsExport is the JSON form of the stats of a worker, merged by its coordinator
+	StatsExport struct {
+		Elapsed time.Duration  `json:"elapsed"`
+		Targets []TargetExport `json:"targets"`
+	}
+	// TargetExport is the JSON form of the stats of a target
+	TargetExport struct {
+		Target      string                 `json:"target"`
+		Requests    int64                  `json:"requests"`
+		Retries     int64                  `json:"retries"`
+		NewConns    int64                  `json:"new_conns"`
+		ReusedConns int64                  `json:"reused_conns"`
+		Failed      int64                  `json:"failed"`
+		Checks      map[string]int64       `json:"checks"`
+		Errors      map[string]int64       `json:"errors"`
+		Codes       map[int]int64          `json:"codes"`
+		Latency     *hdrhistogram.Snapshot `json:"latency"`
+		// ResponseTime is missing from the stats of older workers
+		ResponseTime *hdrhistogram.Snapshot `json:"response_time,omitempty"`
+	}
+	// requestError is returned when a request could not be built from its target
+	requestError struct {
+		err error
+	}
+)
+
+// newStats returns stats that ignore requests completed during the warm-up
+func newStats(warmup time.Duration) *Stats {
+	return &Stats{
+		start:   time.Now().Add(warmup),
+		targets: map[string]*targetStats{},
+	}
+}
+
+func newTargetStats() *targetStats {
+	return &targetStats{
+		checks:       map[string]int64{},
+		errors:       map[string]int64{},
+		codes:        map[int]int64{},
+		latency:      hdrhistogram.New(histogramMin, histogramMax, histogramDigits),
+		responseTime: hdrhistogram.New(histogramMin, histogramMax, histogramDigits),
+	}
+}
+
+// Record adds a completed request to the stats of its target
+func (s *Stats) Record(req *Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Now().Before(s.start) {
+		return
+	}
+	name := req.target.Name()
+	ts, ok := s.targets[name]
+	if !ok {
+		ts = newTargetStats()
+		s.targets[name] = ts
+		s.order = append(s.order, name)
+	}
+	ts.requests++
+	if req.attempts > 1 {
+		ts.retries += int64(req.attempts - 1)
+	}
+	if req.e != nil {
+		ts.errors[errorClass(req.e)]++
+		return
+	}
+	ts.codes[req.r.StatusCode]++
+	if req.connected && req.reused {
+		ts.reusedConns++
+	} else if req.connected {
+		ts.newConns++
+	}
+	if len(req.failed) > 0 {
+		ts.failed++
+		for _, check := range req.failed {
+			ts.checks[checkKind(check)]++
+		}
+	}
+	ts.latency.RecordValue(int64(req.latency / time.Microsecond))
+	ts.responseTime.RecordValue(int64(req.responseTime() / time.Microsecond))
+}
+
+// Summaries returns the summary of every target, followed by a total if there is more than one target
+func (s *Stats) Summaries() []Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return summarize(s.order, s.targets, s.elapsed())
+}
+
+// Export returns the stats in a form that can be sent to a coordinator
+func (s *Stats) Export() StatsExport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	export := StatsExport{Elapsed: s.elapsed(), Targets: make([]TargetExport, 0, len(s.order))}
+	for _, name := range s.order {
+		ts := s.targets[name]
+		export.Targets = append(export.Targets, TargetExport{
+			Target:       name,
+			Requests:     ts.requests,
+			Retries:      ts.retries,
+			NewConns:     ts.newConns,
+			ReusedConns:  ts.reusedConns,
+			Failed:       ts.failed,
+			Checks:       copyCounts(ts.checks),
+			Errors:       copyCounts(ts.errors),
+			Codes:        copyCounts(ts.codes),
+			Latency:      ts.latency.Export(),
+			ResponseTime: ts.responseTime.Export(),
+		})
+	}
+	return export
+}
+
+// mergeExports returns the summaries of the stats of several workers combined, over the longest elapsed time
+func mergeExports(exports []StatsExport) []Summary {
+	var elapsed time.Duration
+	var order []string
+	targets := map[string]*targetStats{}
+	for _, export := range exports {
+		if export.Elapsed > elapsed {
+			elapsed = export.Elapsed
+		}
+		for _, te := range export.Targets {
+			ts, ok := targets[te.Target]
+			if !ok {
+				ts = newTargetStats()
+				targets[te.Target] = ts
+				order = append(order, te.Target)
+			}
+			from := &targetStats{
+				requests:    te.Requests,
+				retries:     te.Retries,
+				newConns:    te.NewConns,
+				reusedConns: te.ReusedConns,
+				failed:      te.Failed,
+				checks:      te.Checks,
+				errors:      te.Errors,
+				codes:       te.Codes,
+				latency:     importHistogram(te.Latency),
+			}
+			from.responseTime = from.latency
+			if te.ResponseTime != nil {
+				from.responseTime = importHistogram(te.ResponseTime)
+			}
+			ts.merge(from)
+		}
+	}
+	return summarize(order, targets, elapsed)
+}
+
+// importHistogram returns the histogram of a snapshot, or an empty histogram if the
+// snapshot is missing or of another shape, which can't be imported safely
+func importHistogram(snapshot *hdrhistogram.Snapshot) *hdrhistogram.Histogram {
+	h := hdrhistogram.New(histogramMin, histogramMax, histogramDigits)
+	if snapshot == nil || snapshot.LowestTrackableValue != histogramMin || snapshot.HighestTrackableValue != histogramMax ||
+		snapshot.SignificantFigures != histogramDigits || len(snapshot.Counts) != len(h.Export().Counts) {
+		return h
+	}
+	return hdrhistogram.Import(snapshot)
+}
+
+// summarize returns the summary of every target, followed by a total if there is more than one target
+func summarize(order []string, targets map[string]*targetStats, elapsed time.Duration) []Summary {
+	summaries := make([]Summary, 0, len(order)+1)
+	total := newTargetStats()
+	for _, name := range order {
+		ts := targets[name]
+		summaries = append(summaries, ts.summary(name, elapsed))
+		total.merge(ts)
+	}
+	if len(order) > 1 {
+		summaries = append(summaries, total.summary(totalTarget, elapsed))
+	}
+	return summaries
+}
+
+// elapsed is the time since the end of the warm-up
+func (s *Stats) elapsed() time.Duration {
+	if elapsed := time.Since(s.start); elapsed > 0 {
+		return elapsed
+	}
+	return 0
+}
+
+// SetPoolSize enables reporting on the open loop request pool
+func (s *Stats) SetPoolSize(size int) {
+	atomic.StoreInt64(&s.poolSize, int64(size))
+}
+
+// InFlight adjusts the number of requests in flight by delta
+func (s *Stats) InFlight(delta int64) {
+	atomic.AddInt64(&s.inFlight, delta)
+}
+
+// Dropped counts a request that was not sent because the pool was full
+func (s *Stats) Dropped() {
+	atomic.AddInt64(&s.dropped, 1)
+}
+
+// Delayed counts a request that waited for a free slot in the pool
+func (s *Stats) Delayed() {
+	atomic.AddInt64(&s.delayed, 1)
+}
+
+// RetryDenied counts a retry refused by the retry budget
+func (s *Stats) RetryDenied() {
+	atomic.AddInt64(&s.retriesDenied, 1)
+}
+
+// Journey counts a journey run, unless it ended during the warm-up
+func (s *Stats) Journey(completed bool) {
+	switch {
+	case time.Now().Before(s.start):
+	case completed:
+		atomic.AddInt64(&s.journeysCompleted, 1)
+	default:
+		atomic.AddInt64(&s.journeysAborted, 1)
+	}
+}
+
+// Report logs a summary line per target
+func (s *Stats) Report(logger *log.Logger) {
+	if time.Now().Before(s.start) {
+		logger.Printf("Warming up, stats are collected from %v", s.start.Format(time.RFC3339))
+		return
+	}
+	for _, summary := range s.Summaries() {
+		logger.Printf("[%v] %v", summary.Target, summary)
+	}
+	if size := atomic.LoadInt64(&s.poolSize); size > 0 {
+		logger.Printf("[pool] size=%d in-flight=%d dropped=%d delayed=%d",
+			size, atomic.LoadInt64(&s.inFlight), atomic.LoadInt64(&s.dropped), atomic.LoadInt64(&s.delayed))
+	}
+	if denied := atomic.LoadInt64(&s.retriesDenied); denied > 0 {
+		logger.Printf("[retries] denied=%d", denied)
+	}
+	completed, aborted := atomic.LoadInt64(&s.journeysCompleted), atomic.LoadInt64(&s.journeysAborted)
+	if completed+aborted > 0 {
+		logger.Printf("[journeys] completed=%d aborted=%d", completed, aborted)
+	}
+}
+
+func (ts *targetStats) merge(from *targetStats) {
+	ts.requests += from.requests
+	ts.retries += from.retries
+	ts.newConns += from.newConns
+	ts.reusedConns += from.reusedConns
+	ts.failed += from.failed
+	for kind, n := range from.checks {
+		ts.checks[kind] += n
+	}
+	for class, n := range from.errors {
+		ts.errors[class] += n
+	}
+	for code, n := range from.codes {
+		ts.codes[code] += n
+	}
+	ts.latency.Merge(from.latency)
+	ts.responseTime.Merge(from.responseTime)
+}
+
+func (ts *targetStats) summary(name string, elapsed time.Duration) Summary {
+	s := Summary{
+		Target:       name,
+		Requests:     ts.requests,
+		Errors:       make(map[string]int64, len(ts.errors)),
+		Codes:        make(map[int]int64, len(ts.codes)),
+		Elapsed:      elapsed,
+		Retries:      ts.retries,
+		NewConns:     ts.newConns,
+		ReusedConns:  ts.reusedConns,
+		Failed:       ts.failed,
+		Checks:       make(map[string]int64, len(ts.checks)),
+		Latency:      latencyOf(ts.latency),
+		ResponseTime: latencyOf(ts.responseTime),
+	}
+	for class, n := range ts.errors {
+		s.Errors[class] = n
+	}
+	for code, n := range ts.codes {
+		s.Codes[code] = n
+	}
+	for kind, n := range ts.checks {
+		s.Checks[kind] = n
+	}
+	return s
+}
+
+func copyCounts[K comparable](counts map[K]int64) map[K]int64 {
+	copied := make(map[K]int64, len(counts))
+	for k, n := range counts {
+		copied[k] = n
+	}
+	return copied
+}
+
+func latencyOf(h *hdrhistogram.Histogram) Latency {
+	return Latency{
+		P50:  quantile(h, 50),
+		P90:  quantile(h, 90),
+		P95:  quantile(h, 95),
+		P99:  quantile(h, 99),
+		P999: quantile(h, 99.9),
+		Max:  time.Duration(h.Max()) * time.Microsecond,
+		Mean: time.Duration(h.Mean()) * time.Microsecond,
+	}
+}
+
+func quantile(h *hdrhistogram.Histogram, percentile float64) time.Duration {
+	return time.Duration(h.ValueAtQuantile(percentile)) * time.Microsecond
+}
+
+// ErrorCount is the number of requests that failed without a response
+func (s Summary) ErrorCount() int64 {
+	var n int64
+	for _, count := range s.Errors {
+		n += count
+	}
+	return n
+}
+
+// ServerErrorCount is the number of requests that got a 5xx response
+func (s Summary) ServerErrorCount() int64 {
+	var n int64
+	for code, count := range s.Codes {
+		if code >= 500 && code <= 599 {
+			n += count
+		}
+	}
+	return n
+}
+
+// RPS is the achieved throughput
+func (s Summary) RPS() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Requests) / s.Elapsed.Seconds()
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("requests=%d rps=%.1f retries=%d errors=%d%s failed=%d%s codes=%s new-conns=%d reused-conns=%d p50=%v p90=%v p95=%v p99=%v p99.9=%v max=%v corrected-p50=%v corrected-p99=%v corrected-max=%v",
+		s.Requests, s.RPS(), s.Retries, s.ErrorCount(), formatErrors(s.Errors), s.Failed, formatErrors(s.Checks), formatCodes(s.Codes), s.NewConns, s.ReusedConns,
+		roundLatency(s.Latency.P50), roundLatency(s.Latency.P90), roundLatency(s.Latency.P95),
+		roundLatency(s.Latency.P99), roundLatency(s.Latency.P999), roundLatency(s.Latency.Max),
+		roundLatency(s.ResponseTime.P50), roundLatency(s.ResponseTime.P99), roundLatency(s.ResponseTime.Max))
+}
+
+// rounds to the 3 significant digits tracked by the histograms
+func roundLatency(d time.Duration) time.Duration {
+	unit := time.Duration(1)
+	for d/unit >= 1000 {
+		unit *= 10
+	}
+	return d.Round(unit)
+}
+
+func formatCodes(codes map[int]int64) string {
+	sorted := make([]int, 0, len(codes))
+	for code := range codes {
+		sorted = append(sorted, code)
+	}
+	sort.Ints(sorted)
+	counts := make([]string, 0, len(sorted))
+	for _, code := range sorted {
+		counts = append(counts, strconv.Itoa(code)+":"+strconv.FormatInt(codes[code], 10))
+	}
+	return strings.Join(counts, ",")
+}
+
+func formatErrors(errors map[string]int64) string {
+	if len(errors) == 0 {
+		return ""
+	}
+	classes := make([]string, 0, len(errors))
+	for class := range errors {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	counts := make([]string, 0, len(classes))
+	for _, class := range classes {
+		counts = append(counts, class+":"+strconv.FormatInt(errors[class], 10))
+	}
+	return "(" + strings.Join(counts, ",") + ")"
+}
+
+func (e *requestError) Error() string {
+	return "invalid request: " + e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// errorClasses are the classes returned by errorClass
+var errorClasses = []string{"invalid_request", "auth", "circuit_open", "timeout", "dns", "connection_refused", "connection_reset", "eof", "tls", "other"}
+
+// errorClass groups transport errors for reporting
+func errorClass(err error) string {
+	var (
+		reqErr       *requestError
+		authErr      *authError
+		netErr       net.Error
+		dnsErr       *net.DNSError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		certErr      x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &reqErr):
+		return "invalid_request"
+	case errors.As(err, &authErr):
+		return "auth"
+	case errors.Is(err, errCircuitOpen):
+		return "circuit_open"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connection_refused"
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return "connection_reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.As(err, &recordErr), errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &certErr):
+		return "tls"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,168 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStats_Summaries(t *testing.T) {
+	a, _ := parseTarget("http://a/")
+	b, _ := parseTarget("http://b/")
+	stats := newStats(0)
+	for i := 1; i <= 100; i++ {
+		stats.Record(&Request{target: a, r: &http.Response{StatusCode: 200}, latency: time.Duration(i) * time.Millisecond})
+	}
+	stats.Record(&Request{target: a, r: &http.Response{StatusCode: 500}, latency: time.Second})
+	stats.Record(&Request{target: b, e: syscall.ECONNREFUSED})
+
+	summaries := stats.Summaries()
+	if len(summaries) != 3 {
+		t.Fatalf("Stats.Summaries() = %d summaries, want 2 targets and a total", len(summaries))
+	}
+	tests := []struct {
+		name    string
+		summary Summary
+		want    []string
+	}{
+		{name: "latency", summary: summaries[0], want: []string{"requests=101", "errors=0 ", "codes=200:100,500:1", "p50=51ms", "p99=100ms", "max=1s"}},
+		{name: "errors", summary: summaries[1], want: []string{"requests=1", "errors=1(connection_refused:1)", "codes= "}},
+		{name: "total", summary: summaries[2], want: []string{"requests=102", "errors=1(connection_refused:1)", "codes=200:100,500:1", "max=1s"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.summary.String()
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Summary.String() = %v, want it to contain %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestStats_Record_warmup(t *testing.T) {
+	a, _ := parseTarget("http://a/")
+	stats := newStats(time.Hour)
+	stats.Record(&Request{target: a, r: &http.Response{StatusCode: 200}, latency: time.Millisecond})
+	if got := stats.Summaries(); len(got) != 0 {
+		t.Errorf("Stats.Summaries() during warm-up = %v, want none", got)
+	}
+}
+
+func Test_errorClass(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "invalid request", err: &requestError{err: errors.New("missing key")}, want: "invalid_request"},
+		{name: "auth", err: &authError{err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: "auth"},
+		{name: "deadline", err: fmt.Errorf("get: %w", context.DeadlineExceeded), want: "timeout"},
+		{name: "net timeout", err: &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, want: "timeout"},
+		{name: "dns", err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "x"}}, want: "dns"},
+		{name: "refused", err: &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, want: "connection_refused"},
+		{name: "reset", err: &net.OpError{Op: "read", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, want: "connection_reset"},
+		{name: "eof", err: fmt.Errorf("get: %w", io.EOF), want: "eof"},
+		{name: "other", err: errors.New("boom"), want: "other"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorClass(tt.err); got != tt.want {
+				t.Errorf("errorClass() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStats_Record_failed(t *testing.T) {
+	target, _ := parseTarget("http://a/")
+	stats := newStats(0)
+	stats.Record(&Request{target: target, r: &http.Response{StatusCode: 200}})
+	stats.Record(&Request{target: target, r: &http.Response{StatusCode: 500}, failed: []string{"status", "json:data.id", "json:data.name"}})
+	summary := stats.Summaries()[0]
+	if summary.Failed != 1 || summary.ErrorCount() != 0 {
+		t.Errorf("Stats.Record() failed = %v, errors = %v, want 1 failed response and no errors", summary.Failed, summary.ErrorCount())
+	}
+	if want := "failed=1(json:2,status:1)"; !strings.Contains(summary.String(), want) {
+		t.Errorf("Summary.String() = %v, want %v", summary, want)
+	}
+}
+
+func Test_mergeExports(t *testing.T) {
+	target, _ := parseTarget("http://a/")
+	var exports []StatsExport
+	for i := 0; i < 2; i++ {
+		stats := newStats(0)
+		for j := 1; j <= 50; j++ {
+			stats.Record(&Request{target: target, r: &http.Response{StatusCode: 200}, latency: time.Duration(i*50+j) * time.Millisecond})
+		}
+		stats.Record(&Request{target: target, e: syscall.ECONNREFUSED})
+		// round trip through JSON as a worker sends its stats to a coordinator
+		b, err := json.Marshal(stats.Export())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var export StatsExport
+		if err := json.Unmarshal(b, &export); err != nil {
+			t.Fatal(err)
+		}
+		exports = append(exports, export)
+	}
+	summaries := mergeExports(exports)
+	if len(summaries) != 1 {
+		t.Fatalf("mergeExports() = %d summaries, want 1", len(summaries))
+	}
+	got := summaries[0].String()
+	for _, want := range []string{"requests=102", "errors=2(connection_refused:2)", "codes=200:100", "p50=50ms", "max=100ms"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("mergeExports() = %v, want it to contain %v", got, want)
+		}
+	}
+}
+
+func TestStats_Record_responseTime(t *testing.T) {
+	target, _ := parseTarget("http://a/")
+	stats := newStats(0)
+	now := time.Now()
+	stats.Record(&Request{target: target, r: &http.Response{StatusCode: 200}, start: now, intended: now, latency: 10 * time.Millisecond})
+	// sent 90ms late, after waiting on a slow response
+	stats.Record(&Request{target: target, r: &http.Response{StatusCode: 200}, start: now, intended: now.Add(-90 * time.Millisecond), latency: 10 * time.Millisecond})
+	summary := stats.Summaries()[0]
+	if got := roundLatency(summary.Latency.Max); got != 10*time.Millisecond {
+		t.Errorf("Summary.Latency.Max = %v, want the 10ms service time", got)
+	}
+	if got := roundLatency(summary.ResponseTime.Max); got != 100*time.Millisecond {
+		t.Errorf("Summary.ResponseTime.Max = %v, want 100ms from the intended send time", got)
+	}
+}
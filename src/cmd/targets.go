@@ -0,0 +1,470 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/spf13/pflag"
+)
+
+type (
+	// Target is a URL the worker sends requests to
+	Target struct {
+		URL          string            `json:"url"`
+		Weight       int               `json:"weight"`
+		Method       string            `json:"method"`
+		Header       map[string]string `json:"header"`
+		Body         string            `json:"body"`
+		BodyFile     string            `json:"body-file"`
+		BodyTemplate string            `json:"body-template"`
+		Check        Checks            `json:"check"`
+		Auth         string            `json:"auth"`
+
+		u         *url.URL
+		auth      authenticator
+		name      string
+		urlTpl    *template.Template
+		headerTpl map[string]*template.Template
+		body      []byte
+		bodyTpl   *template.Template
+	}
+	// targetsValue is a repeatable flag of targets, each either `URL [WEIGHT]` or a JSON object
+	targetsValue struct {
+		targets []*Target
+		changed bool
+	}
+	// targetPicker selects targets at random in proportion to their weights,
+	// the targets can be replaced while picking
+	targetPicker struct {
+		mu         sync.RWMutex
+		targets    []*Target
+		cumulative []int
+	}
+)
+
+// parses a target from `URL [WEIGHT]` or a JSON object, ex `{"url": "http://localhost:8080/", "weight": 3}`
+func parseTarget(spec string) (*Target, error) {
+	t := &Target{Weight: 1}
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "{") {
+		if err := json.Unmarshal([]byte(spec), t); err != nil {
+			return nil, fmt.Errorf("invalid target %s: %v", spec, err)
+		}
+	} else {
+		// the weight is an optional last field, URL templates may contain spaces
+		t.URL = spec
+		if i := strings.LastIndexAny(spec, " \t"); i > 0 {
+			if weight, err := strconv.Atoi(spec[i+1:]); err == nil {
+				t.URL, t.Weight = strings.TrimSpace(spec[:i]), weight
+			}
+		}
+	}
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// validates the target and fills in defaults
+func (t *Target) init() error {
+	if t.Weight < 1 {
+		return fmt.Errorf("invalid target %s: weight must be at least 1", t.URL)
+	}
+	if strings.Contains(t.URL, "{{") {
+		// templated URLs are validated once rendered
+		return nil
+	}
+	u, err := parseTargetURL(t.URL)
+	if err != nil {
+		return err
+	}
+	t.u = u
+	return nil
+}
+
+// parseTargetURL parses the URL of a target, which must be an http(s) URL with a host
+func parseTargetURL(raw string) (*url.URL, error) {
+	if strings.ContainsAny(raw, " \t") {
+		return nil, fmt.Errorf("invalid target %q: URL must not contain spaces", raw)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %s: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid target %s: scheme must be http or https", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid target %s: missing host", raw)
+	}
+	return u, nil
+}
+
+// inherit fills in the request options the target does not set itself from defaults
+func (t *Target) inherit(defaults *Target) {
+	if t.Method == "" {
+		t.Method = defaults.Method
+	}
+	if t.Body == "" && t.BodyFile == "" && t.BodyTemplate == "" {
+		t.Body, t.BodyFile, t.BodyTemplate = defaults.Body, defaults.BodyFile, defaults.BodyTemplate
+	}
+	header := map[string]string{}
+	for k, v := range defaults.Header {
+		header[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range t.Header {
+		header[http.CanonicalHeaderKey(k)] = v
+	}
+	t.Header = header
+	t.Check.inherit(defaults.Check)
+	if t.Auth == "" {
+		// targets share the authenticator, and oauth2 token, of defaults
+		t.Auth, t.auth = defaults.Auth, defaults.auth
+	}
+}
+
+// compile prepares the URL, header and body templates and loads body files
+func (t *Target) compile() error {
+	var err error
+	if t.Method == "" {
+		t.Method = http.MethodGet
+	}
+	if t.u == nil {
+		if t.urlTpl, err = newTemplate("url", t.URL); err != nil {
+			return fmt.Errorf("invalid target %s: %v", t.URL, err)
+		}
+	}
+	t.headerTpl = map[string]*template.Template{}
+	for k, v := range t.Header {
+		if t.headerTpl[k], err = newTemplate(k, v); err != nil {
+			return fmt.Errorf("invalid header %s for target %s: %v", k, t.URL, err)
+		}
+	}
+	switch {
+	case t.BodyTemplate != "":
+		text := t.BodyTemplate
+		if strings.HasPrefix(text, "@") {
+			b, err := os.ReadFile(text[1:])
+			if err != nil {
+				return fmt.Errorf("unable to read body template for target %s: %v", t.URL, err)
+			}
+			text = string(b)
+		}
+		if t.bodyTpl, err = newTemplate("body", text); err != nil {
+			return fmt.Errorf("invalid body template for target %s: %v", t.URL, err)
+		}
+	case t.BodyFile != "":
+		if t.body, err = os.ReadFile(t.BodyFile); err != nil {
+			return fmt.Errorf("unable to read body file for target %s: %v", t.URL, err)
+		}
+	default:
+		t.body = []byte(t.Body)
+	}
+	if err := t.Check.compile(); err != nil {
+		return fmt.Errorf("invalid check for target %s: %v", t.URL, err)
+	}
+	if t.auth == nil {
+		if t.auth, err = parseAuth(t.Auth); err != nil {
+			return fmt.Errorf("invalid auth for target %s: %v", t.URL, err)
+		}
+	}
+	return nil
+}
+
+// newRequest renders the target's templates with data into a request
+func (t *Target) newRequest(data interface{}) (*http.Request, error) {
+	u := t.u
+	if t.urlTpl != nil {
+		rendered, err := render(t.urlTpl, data)
+		if err != nil {
+			return nil, err
+		}
+		if u, err = parseTargetURL(string(rendered)); err != nil {
+			return nil, err
+		}
+	}
+	body := t.body
+	if t.bodyTpl != nil {
+		var err error
+		if body, err = render(t.bodyTpl, data); err != nil {
+			return nil, err
+		}
+	}
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(t.Method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	for k, tpl := range t.headerTpl {
+		v, err := render(tpl, data)
+		if err != nil {
+			return nil, err
+		}
+		if k == "Host" {
+			req.Host = string(v)
+		} else {
+			req.Header.Set(k, string(v))
+		}
+	}
+	return req, nil
+}
+
+// legacyURL builds the target URL of --url and --port. The port of the URL wins, then an
+// explicitly set port, then the https default port, then the --port default for http URLs.
+func legacyURL(raw string, port int, portSet bool) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if ip := net.ParseIP(strings.Trim(raw, "[]")); ip != nil && strings.Contains(raw, ":") && !strings.Contains(raw, "]:") {
+		// a bare IPv6 literal, ex ::1
+		raw = "[" + ip.String() + "]"
+	}
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid --url %v: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("invalid --url %v: scheme must be http or https", raw)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("invalid --url %v: missing host", raw)
+	}
+	switch {
+	case u.Port() != "":
+		if portSet && u.Port() != strconv.Itoa(port) {
+			return "", fmt.Errorf("--url %v and --port %d set different ports", raw, port)
+		}
+	case portSet || u.Scheme == "http":
+		u.Host = net.JoinHostPort(u.Hostname(), strconv.Itoa(port))
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String(), nil
+}
+
+// parses `Key: Value` headers
+func parseHeaders(headers []string) (map[string]string, error) {
+	parsed := map[string]string{}
+	for _, h := range headers {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid header %q: expected Key: Value", h)
+		}
+		parsed[http.CanonicalHeaderKey(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	return parsed, nil
+}
+
+// Name identifies the target in logs and stats
+func (t *Target) Name() string {
+	if t.name != "" {
+		return t.name
+	}
+	return t.URL
+}
+
+// nameTargets names the targets sharing a URL after their method, numbering those that
+// still collide, so each target keeps its own stats
+func nameTargets(targets []*Target) {
+	byURL := map[string][]*Target{}
+	for _, t := range targets {
+		if t.name == "" {
+			byURL[t.URL] = append(byURL[t.URL], t)
+		}
+	}
+	for _, same := range byURL {
+		if len(same) < 2 {
+			continue
+		}
+		seen := map[string]int{}
+		for _, t := range same {
+			method := t.Method
+			if method == "" {
+				method = http.MethodGet
+			}
+			name := method + " " + t.URL
+			if seen[name]++; seen[name] > 1 {
+				name = fmt.Sprintf("%s #%d", name, seen[name])
+			}
+			t.name = name
+		}
+	}
+}
+
+// returns the targets of a flag defined with targetsValue
+func getTargets(fs *pflag.FlagSet, name string) []*Target {
+	flag := fs.Lookup(name)
+	if flag == nil {
+		return nil
+	}
+	if v, ok := flag.Value.(*targetsValue); ok {
+		return v.targets
+	}
+	return nil
+}
+
+func (v *targetsValue) Set(spec string) error {
+	t, err := parseTarget(spec)
+	if err != nil {
+		return err
+	}
+	if !v.changed {
+		v.targets = nil
+		v.changed = true
+	}
+	v.targets = append(v.targets, t)
+	return nil
+}
+
+func (v *targetsValue) Type() string {
+	return "target"
+}
+
+func (v *targetsValue) String() string {
+	if len(v.targets) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(v.GetSlice(), ",") + "]"
+}
+
+func (v *targetsValue) Append(spec string) error {
+	t, err := parseTarget(spec)
+	if err != nil {
+		return err
+	}
+	v.targets = append(v.targets, t)
+	return nil
+}
+
+func (v *targetsValue) Replace(specs []string) error {
+	targets := make([]*Target, 0, len(specs))
+	for _, spec := range specs {
+		t, err := parseTarget(spec)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, t)
+	}
+	v.targets = targets
+	v.changed = true
+	return nil
+}
+
+func (v *targetsValue) GetSlice() []string {
+	specs := make([]string, 0, len(v.targets))
+	for _, t := range v.targets {
+		specs = append(specs, t.URL+" "+strconv.Itoa(t.Weight))
+	}
+	return specs
+}
+
+// configList returns the targets as the list of objects accepted in config files
+func (v *targetsValue) configList() []interface{} {
+	list := make([]interface{}, 0, len(v.targets))
+	for _, t := range v.targets {
+		b, _ := json.Marshal(t)
+		var object map[string]interface{}
+		json.Unmarshal(b, &object)
+		list = append(list, withoutEmpty(object))
+	}
+	return list
+}
+
+// withoutEmpty removes the empty values of a JSON object, recursively
+func withoutEmpty(object map[string]interface{}) map[string]interface{} {
+	for k, v := range object {
+		switch v := v.(type) {
+		case nil:
+			delete(object, k)
+		case string:
+			if v == "" {
+				delete(object, k)
+			}
+		case []interface{}:
+			if len(v) == 0 {
+				delete(object, k)
+			}
+		case map[string]interface{}:
+			if len(withoutEmpty(v)) == 0 {
+				delete(object, k)
+			}
+		}
+	}
+	return object
+}
+
+func newTargetPicker(targets []*Target) *targetPicker {
+	p := &targetPicker{}
+	p.Set(targets)
+	return p
+}
+
+// Set replaces the targets to pick from
+func (p *targetPicker) Set(targets []*Target) {
+	nameTargets(targets)
+	cumulative := make([]int, 0, len(targets))
+	total := 0
+	for _, t := range targets {
+		total += t.Weight
+		cumulative = append(cumulative, total)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.targets, p.cumulative = targets, cumulative
+}
+
+// Targets returns the targets to pick from
+func (p *targetPicker) Targets() []*Target {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.targets
+}
+
+// Pick returns a random target, weighted by Target.Weight
+func (p *targetPicker) Pick() *Target {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if len(p.targets) == 1 {
+		return p.targets[0]
+	}
+	n := rand.Intn(p.cumulative[len(p.cumulative)-1])
+	return p.targets[sort.SearchInts(p.cumulative, n+1)]
+}
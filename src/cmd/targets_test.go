@@ -0,0 +1,289 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func Test_parseTarget(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		wantURL    string
+		wantWeight int
+		wantErr    bool
+	}{
+		{name: "url", spec: "http://localhost:8080/", wantURL: "http://localhost:8080/", wantWeight: 1},
+		{name: "url and weight", spec: "https://example.com/search?q=a 3", wantURL: "https://example.com/search?q=a", wantWeight: 3},
+		{name: "json", spec: `{"url": "http://localhost/items", "weight": 2}`, wantURL: "http://localhost/items", wantWeight: 2},
+		{name: "json default weight", spec: `{"url": "http://localhost/items"}`, wantURL: "http://localhost/items", wantWeight: 1},
+		{name: "template", spec: "http://localhost/items/{{ seq }}", wantURL: "http://localhost/items/{{ seq }}", wantWeight: 1},
+		{name: "template and weight", spec: "http://localhost/items/{{ seq }} 2", wantURL: "http://localhost/items/{{ seq }}", wantWeight: 2},
+		{name: "template and query", spec: "http://localhost/{{ seq }}?q=1", wantURL: "http://localhost/{{ seq }}?q=1", wantWeight: 1},
+		{name: "bad weight", spec: "http://localhost/ x", wantErr: true},
+		{name: "zero weight", spec: "http://localhost/ 0", wantErr: true},
+		{name: "too many fields", spec: "http://localhost/ 1 2", wantErr: true},
+		{name: "no scheme", spec: "localhost:8080", wantErr: true},
+		{name: "no host", spec: "http:///path", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTarget(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.URL != tt.wantURL || got.Weight != tt.wantWeight {
+				t.Errorf("parseTarget() = %v %v, want %v %v", got.URL, got.Weight, tt.wantURL, tt.wantWeight)
+			}
+		})
+	}
+}
+
+func Test_legacyURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		port    int
+		portSet bool
+		want    string
+		wantErr bool
+	}{
+		{name: "defaults", url: "http://localhost", port: 8080, want: "http://localhost:8080/"},
+		{name: "port in url", url: "http://localhost:9000", port: 8080, want: "http://localhost:9000/"},
+		{name: "same port in both", url: "http://localhost:9000", port: 9000, portSet: true, want: "http://localhost:9000/"},
+		{name: "different ports", url: "http://localhost:9000", port: 8080, portSet: true, wantErr: true},
+		{name: "path and query", url: "http://example.com/api/items?limit=10", port: 8080, want: "http://example.com:8080/api/items?limit=10"},
+		{name: "https default port", url: "https://example.com/api", port: 8080, want: "https://example.com/api"},
+		{name: "https explicit port", url: "https://example.com", port: 8443, portSet: true, want: "https://example.com:8443/"},
+		{name: "no scheme", url: "localhost", port: 8080, want: "http://localhost:8080/"},
+		{name: "ipv6", url: "http://[::1]", port: 8080, want: "http://[::1]:8080/"},
+		{name: "ipv6 with port", url: "http://[::1]:9000/x", port: 8080, want: "http://[::1]:9000/x"},
+		{name: "bare ipv6", url: "::1", port: 8080, want: "http://[::1]:8080/"},
+		{name: "bad scheme", url: "ftp://example.com", port: 8080, wantErr: true},
+		{name: "no host", url: "http:///path", port: 8080, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := legacyURL(tt.url, tt.port, tt.portSet)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("legacyURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("legacyURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_targetsValue(t *testing.T) {
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.Var(&targetsValue{}, "target", "")
+	if err := fs.Parse([]string{"--target", "http://a/ 2", "--target", "http://b/"}); err != nil {
+		t.Fatal(err)
+	}
+	got := getTargets(fs, "target")
+	if len(got) != 2 || got[0].URL != "http://a/" || got[0].Weight != 2 || got[1].URL != "http://b/" {
+		t.Errorf("getTargets() = %v, want http://a/ and http://b/", got)
+	}
+}
+
+func Test_targetPicker(t *testing.T) {
+	a, _ := parseTarget("http://a/ 1")
+	b, _ := parseTarget("http://b/ 3")
+	picker := newTargetPicker([]*Target{a, b})
+	counts := map[*Target]int{}
+	for i := 0; i < 10000; i++ {
+		counts[picker.Pick()]++
+	}
+	if ratio := float64(counts[b]) / float64(counts[a]); ratio < 2.5 || ratio > 3.5 {
+		t.Errorf("targetPicker.Pick() ratio = %.2f, want ~3", ratio)
+	}
+}
+
+func Test_nameTargets(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []*Target
+		want    []string
+	}{
+		{name: "distinct urls", targets: []*Target{{URL: "http://a/", Method: "GET"}, {URL: "http://b/", Method: "GET"}},
+			want: []string{"http://a/", "http://b/"}},
+		{name: "same url", targets: []*Target{{URL: "http://a/", Method: "GET"}, {URL: "http://a/", Method: "POST"}, {URL: "http://b/"}},
+			want: []string{"GET http://a/", "POST http://a/", "http://b/"}},
+		{name: "same method", targets: []*Target{{URL: "http://a/", Method: "POST", Body: "1"}, {URL: "http://a/", Method: "POST", Body: "2"}, {URL: "http://a/"}},
+			want: []string{"POST http://a/", "POST http://a/ #2", "GET http://a/"}},
+		{name: "named", targets: []*Target{{URL: "http://a/", name: "checkout/pay"}, {URL: "http://a/"}},
+			want: []string{"checkout/pay", "http://a/"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nameTargets(tt.targets)
+			for i, target := range tt.targets {
+				if got := target.Name(); got != tt.want[i] {
+					t.Errorf("target %d Name() = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestTarget_newRequest(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "body.json")
+	if err := os.WriteFile(bodyFile, []byte(`{"from": "file"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	defaults := &Target{Method: "POST", Header: map[string]string{"content-type": "application/json"}}
+	tests := []struct {
+		name       string
+		target     Target
+		wantMethod string
+		wantURL    string
+		wantHeader map[string]string
+		wantHost   string
+		wantBody   string
+	}{
+		{
+			name:       "defaults",
+			target:     Target{URL: "http://localhost/items", Body: "{}"},
+			wantMethod: "POST",
+			wantURL:    "http://localhost/items",
+			wantHeader: map[string]string{"Content-Type": "application/json"},
+			wantBody:   "{}",
+		},
+		{
+			name:       "overrides",
+			target:     Target{URL: "http://localhost/items", Method: "PUT", Header: map[string]string{"Content-Type": "text/plain", "Host": "app.example.com"}},
+			wantMethod: "PUT",
+			wantURL:    "http://localhost/items",
+			wantHeader: map[string]string{"Content-Type": "text/plain"},
+			wantHost:   "app.example.com",
+		},
+		{
+			name:       "templates",
+			target:     Target{URL: "http://localhost/items/{{ randInt 7 8 }}", Header: map[string]string{"X-Id": `{{ pick "a" }}`}, BodyTemplate: `{"n": {{ randInt 1 2 }}}`},
+			wantMethod: "POST",
+			wantURL:    "http://localhost/items/7",
+			wantHeader: map[string]string{"X-Id": "a"},
+			wantBody:   `{"n": 1}`,
+		},
+		{
+			name:       "body file",
+			target:     Target{URL: "http://localhost/", BodyFile: bodyFile},
+			wantMethod: "POST",
+			wantURL:    "http://localhost/",
+			wantBody:   `{"from": "file"}`,
+		},
+		{
+			name:       "body template file",
+			target:     Target{URL: "http://localhost/", BodyTemplate: "@" + bodyFile},
+			wantMethod: "POST",
+			wantURL:    "http://localhost/",
+			wantBody:   `{"from": "file"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.target
+			target.Weight = 1
+			if err := target.init(); err != nil {
+				t.Fatal(err)
+			}
+			target.inherit(defaults)
+			if err := target.compile(); err != nil {
+				t.Fatal(err)
+			}
+			req, err := target.newRequest(nil)
+			if err != nil {
+				t.Fatalf("Target.newRequest() error = %v", err)
+			}
+			if req.Method != tt.wantMethod || req.URL.String() != tt.wantURL {
+				t.Errorf("Target.newRequest() = %v %v, want %v %v", req.Method, req.URL, tt.wantMethod, tt.wantURL)
+			}
+			for k, v := range tt.wantHeader {
+				if got := req.Header.Get(k); got != v {
+					t.Errorf("Target.newRequest() header %v = %v, want %v", k, got, v)
+				}
+			}
+			if req.Host != tt.wantHost && tt.wantHost != "" {
+				t.Errorf("Target.newRequest() host = %v, want %v", req.Host, tt.wantHost)
+			}
+			var body []byte
+			if req.Body != nil {
+				body, _ = io.ReadAll(req.Body)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("Target.newRequest() body = %s, want %s", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestTarget_newRequest_renderedURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "valid", url: "http://localhost/items"},
+		{name: "scheme", url: "ftp://localhost/items", wantErr: true},
+		{name: "no host", url: "http:///items", wantErr: true},
+		{name: "spaces", url: "http://local host/", wantErr: true},
+		{name: "relative", url: "/items", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := &Target{URL: "{{ .url }}", Weight: 1, Method: "GET"}
+			if err := target.init(); err != nil {
+				t.Fatal(err)
+			}
+			if err := target.compile(); err != nil {
+				t.Fatal(err)
+			}
+			_, err := target.newRequest(map[string]interface{}{"url": tt.url})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Target.newRequest() with URL %q error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_parseHeaders(t *testing.T) {
+	got, err := parseHeaders([]string{"content-type: application/json", "X-Token:abc:def"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["Content-Type"] != "application/json" || got["X-Token"] != "abc:def" {
+		t.Errorf("parseHeaders() = %v", got)
+	}
+	if _, err := parseHeaders([]string{"no-colon"}); err == nil {
+		t.Errorf("parseHeaders() error = nil, want error")
+	}
+}
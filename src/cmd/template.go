@@ -0,0 +1,90 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var sequence int64
+
+// functions available to URL, header and body templates
+var templateFuncs = template.FuncMap{
+	// randString 8 -> "aZbXcYdW"
+	"randString": randString,
+	// randInt 1 100 -> a number in [1, 100)
+	"randInt": func(min int, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + rand.Intn(max-min)
+	},
+	// uuid -> a random (v4) UUID
+	"uuid": func() string {
+		return uuid.NewString()
+	},
+	// seq -> 1, 2, 3, ... shared by every request of the worker
+	"seq": func() int64 {
+		return atomic.AddInt64(&sequence, 1)
+	},
+	// now -> the current time.Time, ex {{ now.UnixMilli }}
+	"now": time.Now,
+	// timestamp -> the current time in RFC 3339 format
+	"timestamp": func() string {
+		return time.Now().Format(time.RFC3339)
+	},
+	// unix -> the current time in seconds since the epoch
+	"unix": func() int64 {
+		return time.Now().Unix()
+	},
+	// pick "a" "b" "c" -> one of its arguments at random
+	"pick": func(items ...interface{}) interface{} {
+		if len(items) == 0 {
+			return ""
+		}
+		return items[rand.Intn(len(items))]
+	},
+}
+
+func newTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// literal escapes text to render as is when parsed as a template
+func literal(text string) string {
+	return strings.ReplaceAll(text, "{{", `{{"{{"}}`)
+}
+
+func render(tpl *template.Template, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
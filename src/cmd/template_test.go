@@ -0,0 +1,64 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"regexp"
+	"testing"
+)
+
+func Test_render(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		data    interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "literal", text: "hello", want: "^hello$"},
+		{name: "randString", text: "{{ randString 8 }}", want: "^[a-zA-Z]{8}$"},
+		{name: "randInt", text: "{{ randInt 5 6 }}", want: "^5$"},
+		{name: "uuid", text: "{{ uuid }}", want: "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$"},
+		{name: "seq", text: "{{ seq }} {{ seq }}", want: `^(\d+) (\d+)$`},
+		{name: "timestamp", text: "{{ timestamp }}", want: `^\d{4}-\d{2}-\d{2}T`},
+		{name: "unix", text: "{{ unix }}", want: `^\d{10}$`},
+		{name: "now", text: "{{ now.Year }}", want: `^\d{4}$`},
+		{name: "pick", text: `{{ pick "a" "a" }}`, want: "^a$"},
+		{name: "data", text: "{{ .sku }}", data: map[string]string{"sku": "X1"}, want: "^X1$"},
+		{name: "missing key", text: "{{ .sku }}", data: map[string]string{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl, err := newTemplate(tt.name, tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := render(tpl, tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !regexp.MustCompile(tt.want).Match(got) {
+				t.Errorf("render() = %s, want match for %s", got, tt.want)
+			}
+		})
+	}
+}
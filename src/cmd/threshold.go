@@ -0,0 +1,191 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// threshold is a pass/fail condition on the worker's results, ex p99<300ms
+type threshold struct {
+	expr   string
+	metric string
+	op     string
+	value  float64
+}
+
+const correctedPrefix = "corrected_"
+
+var (
+	thresholdExpr = regexp.MustCompile(`^\s*([a-z0-9_.]+)\s*(<=|>=|==|!=|<|>)\s*(\S+)\s*$`)
+
+	// latency metrics are compared in milliseconds, with the correctedPrefix they
+	// compare response times corrected for coordinated omission, ex corrected_p99
+	latencyMetrics = map[string]func(Latency) time.Duration{
+		"p50":   func(l Latency) time.Duration { return l.P50 },
+		"p90":   func(l Latency) time.Duration { return l.P90 },
+		"p95":   func(l Latency) time.Duration { return l.P95 },
+		"p99":   func(l Latency) time.Duration { return l.P99 },
+		"p99.9": func(l Latency) time.Duration { return l.P999 },
+		"max":   func(l Latency) time.Duration { return l.Max },
+		"mean":  func(l Latency) time.Duration { return l.Mean },
+	}
+	countMetrics = map[string]func(Summary) float64{
+		"rps":          func(s Summary) float64 { return s.RPS() },
+		"requests":     func(s Summary) float64 { return float64(s.Requests) },
+		"errors":       func(s Summary) float64 { return float64(s.ErrorCount() + s.ServerErrorCount()) },
+		"retries":      func(s Summary) float64 { return float64(s.Retries) },
+		"error_rate":   errorRate,
+		"failed":       func(s Summary) float64 { return float64(s.Failed) },
+		"failure_rate": failureRate,
+	}
+)
+
+// parseThreshold parses METRIC OP VALUE where METRIC is a latency percentile (p50, p90, p95,
+// p99, p99.9, max or mean, or the same prefixed with corrected_) compared to a duration, error_rate or failure_rate compared to a
+// percentage, or rps, requests, retries, errors or failed compared to a number, and OP is one of <, <=, >, >=, == or !=
+func parseThreshold(expr string) (*threshold, error) {
+	m := thresholdExpr.FindStringSubmatch(expr)
+	if m == nil {
+		return nil, fmt.Errorf("invalid threshold %q: want METRIC OP VALUE, ex p99<300ms", expr)
+	}
+	t := &threshold{expr: strings.TrimSpace(expr), metric: m[1], op: m[2]}
+	var err error
+	switch _, latency := latencyMetrics[strings.TrimPrefix(t.metric, correctedPrefix)]; {
+	case latency:
+		var d time.Duration
+		d, err = time.ParseDuration(m[3])
+		t.value = millis(d)
+	case t.metric == "error_rate", t.metric == "failure_rate":
+		t.value, err = strconv.ParseFloat(strings.TrimSuffix(m[3], "%"), 64)
+	case countMetrics[t.metric] != nil:
+		t.value, err = strconv.ParseFloat(m[3], 64)
+	default:
+		return nil, fmt.Errorf("invalid threshold %q: unknown metric %q", expr, t.metric)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid threshold %q: %v", expr, err)
+	}
+	return t, nil
+}
+
+// Check evaluates the threshold against a summary, returning the measured value
+func (t *threshold) Check(s Summary) (string, bool) {
+	var actual float64
+	var formatted string
+	if latency, ok := latencyMetrics[strings.TrimPrefix(t.metric, correctedPrefix)]; ok {
+		l := s.Latency
+		if strings.HasPrefix(t.metric, correctedPrefix) {
+			l = s.ResponseTime
+		}
+		d := latency(l)
+		actual, formatted = millis(d), roundLatency(d).String()
+	} else {
+		actual = countMetrics[t.metric](s)
+		formatted = strconv.FormatFloat(actual, 'f', -1, 64)
+		switch t.metric {
+		case "error_rate", "failure_rate":
+			formatted = strconv.FormatFloat(actual, 'f', 2, 64) + "%"
+		case "rps":
+			formatted = strconv.FormatFloat(actual, 'f', 1, 64)
+		}
+	}
+	switch t.op {
+	case "<":
+		return formatted, actual < t.value
+	case "<=":
+		return formatted, actual <= t.value
+	case ">":
+		return formatted, actual > t.value
+	case ">=":
+		return formatted, actual >= t.value
+	case "==":
+		return formatted, actual == t.value
+	default:
+		return formatted, actual != t.value
+	}
+}
+
+// errorRate is the percentage of requests that failed without a response or with a 5xx status
+func errorRate(s Summary) float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.ErrorCount()+s.ServerErrorCount()) / float64(s.Requests) * 100
+}
+
+// failureRate is the percentage of requests whose response failed its checks
+func failureRate(s Summary) float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Failed) / float64(s.Requests) * 100
+}
+
+func parseThresholds(exprs []string) ([]*threshold, error) {
+	thresholds := make([]*threshold, 0, len(exprs))
+	for _, expr := range exprs {
+		t, err := parseThreshold(expr)
+		if err != nil {
+			return nil, err
+		}
+		thresholds = append(thresholds, t)
+	}
+	return thresholds, nil
+}
+
+// checkThresholds logs whether each threshold passed and returns them as JUnit test cases,
+// along with the number that failed
+func checkThresholds(thresholds []*threshold, total Summary, logger *log.Logger) ([]junitCase, int) {
+	failed := 0
+	cases := make([]junitCase, 0, len(thresholds))
+	for _, t := range thresholds {
+		actual, ok := t.Check(total)
+		if total.Requests == 0 {
+			// metrics of a run that sent nothing would pass vacuously, ex p99<300ms
+			actual, ok = "none, no requests were recorded", false
+		}
+		tc := junitCase{Name: t.expr, Classname: "worker.thresholds", Time: junitSeconds(total.Elapsed)}
+		if ok {
+			logger.Printf("Threshold %v passed, %v=%v", t.expr, t.metric, actual)
+		} else {
+			failed++
+			logger.Printf("Threshold %v failed, %v=%v", t.expr, t.metric, actual)
+			tc.Failure = &junitFailure{Message: fmt.Sprintf("%v=%v", t.metric, actual), Type: "threshold", Text: total.String()}
+		}
+		cases = append(cases, tc)
+	}
+	return cases, failed
+}
+
+// totalSummary returns the summary of every target combined
+func totalSummary(summaries []Summary) Summary {
+	if len(summaries) == 0 {
+		return Summary{Target: totalTarget}
+	}
+	return summaries[len(summaries)-1]
+}
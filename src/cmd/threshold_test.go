@@ -0,0 +1,154 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseThreshold(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    threshold
+		wantErr bool
+	}{
+		{name: "latency", expr: "p99<300ms", want: threshold{expr: "p99<300ms", metric: "p99", op: "<", value: 300}},
+		{name: "percentile with a dot", expr: "p99.9 <= 1s", want: threshold{expr: "p99.9 <= 1s", metric: "p99.9", op: "<=", value: 1000}},
+		{name: "error rate", expr: "error_rate<1%", want: threshold{expr: "error_rate<1%", metric: "error_rate", op: "<", value: 1}},
+		{name: "rps", expr: "rps>=95", want: threshold{expr: "rps>=95", metric: "rps", op: ">=", value: 95}},
+		{name: "unknown metric", expr: "p42<1s", wantErr: true},
+		{name: "corrected latency", expr: "corrected_p99<1s", want: threshold{expr: "corrected_p99<1s", metric: "corrected_p99", op: "<", value: 1000}},
+		{name: "corrected count", expr: "corrected_rps>1", wantErr: true},
+		{name: "latency without unit", expr: "p99<300", wantErr: true},
+		{name: "no operator", expr: "p99 300ms", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseThreshold(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseThreshold() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("parseThreshold() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_threshold_Check(t *testing.T) {
+	summary := Summary{
+		Requests: 200,
+		Errors:   map[string]int64{"timeout": 4},
+		Elapsed:  2 * time.Second,
+		Latency:  Latency{P99: 250 * time.Millisecond},
+		// requests sent late waited 1s past their intended send time
+		ResponseTime: Latency{P99: 1250 * time.Millisecond},
+	}
+	serverErrors := summary
+	serverErrors.Errors, serverErrors.Codes = nil, map[int]int64{200: 190, 404: 4, 500: 4, 503: 2}
+	tests := []struct {
+		expr       string
+		summary    *Summary
+		wantActual string
+		want       bool
+	}{
+		{expr: "p99<300ms", wantActual: "250ms", want: true},
+		{expr: "p99<200ms", wantActual: "250ms", want: false},
+		{expr: "corrected_p99<300ms", wantActual: "1.25s", want: false},
+		{expr: "error_rate<1%", wantActual: "2.00%", want: false},
+		{expr: "error_rate<=2", wantActual: "2.00%", want: true},
+		{expr: "rps>=95", wantActual: "100.0", want: true},
+		{expr: "errors==0", wantActual: "4", want: false},
+		{expr: "error_rate<1%", summary: &serverErrors, wantActual: "3.00%", want: false},
+		{expr: "errors==6", summary: &serverErrors, wantActual: "6", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			th, err := parseThreshold(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := summary
+			if tt.summary != nil {
+				s = *tt.summary
+			}
+			actual, ok := th.Check(s)
+			if actual != tt.wantActual || ok != tt.want {
+				t.Errorf("threshold.Check() = %v, %v, want %v, %v", actual, ok, tt.wantActual, tt.want)
+			}
+		})
+	}
+}
+
+func Test_checkThresholds(t *testing.T) {
+	thresholds, err := parseThresholds([]string{"p99<300ms", "error_rate<1", "requests>=0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		total      Summary
+		wantFailed int
+	}{
+		{name: "passing", total: Summary{Requests: 10, Elapsed: time.Second, Latency: Latency{P99: 100 * time.Millisecond}}},
+		{name: "no requests", total: Summary{Target: totalTarget}, wantFailed: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cases, failed := checkThresholds(thresholds, tt.total, log.New(io.Discard, "", 0))
+			if failed != tt.wantFailed || len(cases) != len(thresholds) {
+				t.Errorf("checkThresholds() = %d cases, %d failed, want %d failed", len(cases), failed, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestWorker_thresholds(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	healthPort := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	rootCmd.SetArgs([]string{"worker", "-t", srv.URL + "/", "-r", "100", "--requests", "10", "--report-interval", "0",
+		"-P", strconv.Itoa(healthPort), "--threshold", "error_rate<1%"})
+	rootCmd.SetOut(io.Discard)
+	rootCmd.SetErr(io.Discard)
+	defer rootCmd.SetArgs(nil)
+	if err := rootCmd.Execute(); err == nil || !strings.Contains(err.Error(), "1 of 1 thresholds failed") {
+		t.Errorf("worker with 500 responses error = %v, want the error_rate threshold to fail", err)
+	}
+}
@@ -0,0 +1,228 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// think time distributions of --think and journey steps
+const (
+	thinkConstant    = "constant"
+	thinkUniform     = "uniform"
+	thinkExponential = "exponential"
+)
+
+type (
+	// thinkTime is the pause of a virtual user between requests, or journey steps,
+	// drawn from a distribution
+	thinkTime struct {
+		kind     string
+		min, max time.Duration // uniform, or the constant time in min
+		mean     time.Duration // exponential
+	}
+	// virtualUsers is the number of users a closed model dispatcher runs, a load
+	// profile ramps it up and down
+	virtualUsers struct {
+		want    int64
+		running int64
+	}
+)
+
+// parseThinkTime parses DURATION, constant:DURATION, uniform:min=DURATION,max=DURATION
+// or exponential:mean=DURATION, an empty spec is no think time
+func parseThinkTime(spec string) (thinkTime, error) {
+	kind, args, ok := strings.Cut(strings.TrimSpace(spec), ":")
+	if !ok {
+		kind, args = thinkConstant, kind
+	}
+	t := thinkTime{kind: kind}
+	switch kind {
+	case thinkConstant:
+		if args == "" {
+			return t, nil
+		}
+		var err error
+		if t.min, err = parseThinkDuration(args); err != nil {
+			return t, fmt.Errorf("invalid think time %q: %v", spec, err)
+		}
+		t.max = t.min
+	case thinkUniform:
+		opts, err := parseOptions(args, "min", "max")
+		if err != nil {
+			return t, fmt.Errorf("invalid think time %q: %v", spec, err)
+		}
+		if t.min, err = parseThinkDuration(opts["min"]); err != nil {
+			return t, fmt.Errorf("invalid think time %q: %v", spec, err)
+		}
+		if t.max, err = parseThinkDuration(opts["max"]); err != nil {
+			return t, fmt.Errorf("invalid think time %q: %v", spec, err)
+		}
+		if t.max < t.min {
+			return t, fmt.Errorf("invalid think time %q: max must be at least min", spec)
+		}
+	case thinkExponential:
+		opts, err := parseOptions(args, "mean")
+		if err != nil {
+			return t, fmt.Errorf("invalid think time %q: %v", spec, err)
+		}
+		if t.mean, err = parseThinkDuration(opts["mean"]); err != nil {
+			return t, fmt.Errorf("invalid think time %q: %v", spec, err)
+		}
+	default:
+		return t, fmt.Errorf("unknown think time %q: must be a duration or one of constant, uniform or exponential", kind)
+	}
+	return t, nil
+}
+
+func parseThinkDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%q must be a non-negative duration, ex 2s", s)
+	}
+	return d, nil
+}
+
+// Next draws a think time
+func (t thinkTime) Next() time.Duration {
+	switch t.kind {
+	case thinkUniform:
+		return t.min + time.Duration(rand.Int63n(int64(t.max-t.min)+1))
+	case thinkExponential:
+		return time.Duration(rand.ExpFloat64() * float64(t.mean))
+	default:
+		return t.min
+	}
+}
+
+// String describes the distribution, ex uniform 1s-3s
+func (t thinkTime) String() string {
+	switch t.kind {
+	case thinkUniform:
+		return fmt.Sprintf("uniform %v-%v", t.min, t.max)
+	case thinkExponential:
+		return fmt.Sprintf("exponential averaging %v", t.mean)
+	default:
+		return t.min.String()
+	}
+}
+
+func newVirtualUsers(n int) *virtualUsers {
+	return &virtualUsers{want: int64(n)}
+}
+
+// Set changes the number of users, rounded to the nearest whole user
+func (u *virtualUsers) Set(users float64) {
+	atomic.StoreInt64(&u.want, int64(math.Round(users)))
+}
+
+// Want is the number of users to run
+func (u *virtualUsers) Want() int {
+	return int(atomic.LoadInt64(&u.want))
+}
+
+// Running is the number of users running
+func (u *virtualUsers) Running() int {
+	return int(atomic.LoadInt64(&u.running))
+}
+
+// runUsers runs d.users virtual users (closed model), each sending a request, or running
+// the journey, and thinking before the next one. Users are started and stopped as their
+// number changes. It returns once every user stopped, after the limit of requests is
+// reached, the feeder is exhausted or ctx is done.
+func (d *dispatcher) runUsers(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg    sync.WaitGroup
+		stops []context.CancelFunc
+		sent  int64
+		done  = make(chan error, 1)
+	)
+	ticker := time.NewTicker(profileTick)
+	defer ticker.Stop()
+	for {
+		want := d.users.Want()
+		for len(stops) < want {
+			userCtx, stop := context.WithCancel(ctx)
+			stops = append(stops, stop)
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				atomic.AddInt64(&d.users.running, 1)
+				defer atomic.AddInt64(&d.users.running, -1)
+				if err := d.user(userCtx, &sent); err != nil {
+					select {
+					case done <- err:
+					default:
+					}
+				}
+			}()
+		}
+		for len(stops) > want {
+			stops[len(stops)-1]()
+			stops = stops[:len(stops)-1]
+		}
+		select {
+		case err := <-done:
+			cancel()
+			wg.Wait()
+			return err
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// user loops until ctx is done, sent reaches the limit or the feeder is exhausted
+func (d *dispatcher) user(ctx context.Context, sent *int64) error {
+	for ctx.Err() == nil {
+		if d.pacer.Paused() {
+			sleepUntil(ctx, time.Now().Add(profileTick))
+			continue
+		}
+		if d.limit > 0 && atomic.AddInt64(sent, 1) > d.limit {
+			return errRequestsDone
+		}
+		var data map[string]interface{}
+		if d.feed != nil {
+			var err error
+			if data, err = d.feed.Next(); err != nil {
+				return err
+			}
+		}
+		d.client.stats.InFlight(1)
+		d.send(ctx, time.Now(), d.picker.Pick(), data)
+		d.client.stats.InFlight(-1)
+		sleepUntil(ctx, time.Now().Add(d.think.Next()))
+	}
+	return nil
+}
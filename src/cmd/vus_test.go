@@ -0,0 +1,125 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package cmd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_parseThinkTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		wantMin time.Duration
+		wantMax time.Duration
+		wantErr bool
+	}{
+		{name: "none", spec: ""},
+		{name: "duration", spec: "2s", wantMin: 2 * time.Second, wantMax: 2 * time.Second},
+		{name: "constant", spec: "constant:500ms", wantMin: 500 * time.Millisecond, wantMax: 500 * time.Millisecond},
+		{name: "uniform", spec: "uniform:min=1s,max=3s", wantMin: time.Second, wantMax: 3 * time.Second},
+		{name: "exponential", spec: "exponential:mean=1s", wantMax: time.Hour},
+		{name: "uniform max below min", spec: "uniform:min=3s,max=1s", wantErr: true},
+		{name: "missing mean", spec: "exponential:max=1s", wantErr: true},
+		{name: "negative", spec: "-1s", wantErr: true},
+		{name: "unknown", spec: "normal:mean=1s", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseThinkTime(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseThinkTime() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for i := 0; i < 100; i++ {
+				if d := got.Next(); d < tt.wantMin || d > tt.wantMax {
+					t.Fatalf("thinkTime.Next() = %v, want between %v and %v", d, tt.wantMin, tt.wantMax)
+				}
+			}
+		})
+	}
+}
+
+func Test_thinkTime_Next_mean(t *testing.T) {
+	tests := []struct {
+		name string
+		spec string
+		want time.Duration
+	}{
+		{name: "uniform", spec: "uniform:min=0s,max=20ms", want: 10 * time.Millisecond},
+		{name: "exponential", spec: "exponential:mean=10ms", want: 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			think, _ := parseThinkTime(tt.spec)
+			var total time.Duration
+			const n = 20000
+			for i := 0; i < n; i++ {
+				total += think.Next()
+			}
+			if mean := total / n; mean < tt.want*9/10 || mean > tt.want*11/10 {
+				t.Errorf("thinkTime.Next() averages %v, want %v", mean, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dispatcher_runUsers(t *testing.T) {
+	d := newTestDispatcher(t, 0, 1)
+	d.users = newVirtualUsers(2)
+	d.think, _ = parseThinkTime("20ms")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- d.Run(ctx) }()
+
+	waitUsers := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for d.users.Running() != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("virtualUsers.Running() = %v, want %v", d.users.Running(), want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitUsers(2)
+	// a profile ramping users up and down
+	d.users.Set(4.6)
+	waitUsers(5)
+	d.users.Set(1)
+	waitUsers(1)
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("dispatcher.Run() error = %v, want %v", err, context.Canceled)
+	}
+	if d.users.Running() != 0 {
+		t.Errorf("virtualUsers.Running() after Run returned = %v, want 0", d.users.Running())
+	}
+	if got := d.client.stats.Summaries()[0].Requests; got == 0 {
+		t.Error("users sent no requests")
+	}
+}
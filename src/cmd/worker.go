@@ -23,40 +23,304 @@ package cmd
 
 import (
 	"context"
-	"strconv"
-	"time"
+	"fmt"
+	"math"
+	"net/http"
 	"os"
+	"strings"
+	"time"
 
 	timerate "golang.org/x/time/rate"
 
 	"github.com/spf13/cobra"
-	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
 	"gopkg.in/DataDog/dd-trace-go.v1/ddtrace/tracer"
+	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
 )
 
 // workerCmd represents the worker command
 var workerCmd = &cobra.Command{
 	Use:   "worker",
 	Short: "Starts a worker instance",
-	Run: func(cmd *cobra.Command, args []string) {
+	PreRunE: validateFlags(
+		checkPort("port", "health-port"),
+		checkRange(0, math.MaxInt32, "rate"),
+		checkPercent("fail", "health-fail"),
+		checkDuration("report-interval", "warmup", "duration", "max-latency"),
+		checkExclusive("body", "body-file", "body-template"),
+		checkOneOf("feeder-mode", feederSequential, feederRandom, feederOnce),
+		checkNonNegative("max-in-flight", "requests", "log-failures", "retry-budget"),
+		checkRange(1, math.MaxInt32, "max-attempts"),
+		checkDuration("retry-backoff", "retry-max-backoff"),
+		checkOneOf("retry-jitter", jitterNone, jitterFull, jitterEqual),
+		checkNonNegative("breaker-failures", "breaker-window"),
+		checkPercent("breaker-failure-rate"),
+		checkRange(1, math.MaxInt32, "breaker-probes"),
+		checkDuration("breaker-open", "dns-refresh"),
+		checkNonNegative("max-idle-conns-per-host", "max-conns-per-host", "reconnect-every"),
+		checkOneOf("prefer-ip", ipAny, ipV4, ipV6),
+		checkOneOf("arrivals", arrivalsConstant, arrivalsPoisson),
+		checkOneOf("on-full", onFullDrop, onFullDelay),
+		checkOneOf("out-detail", outSummary, outRequests),
+		checkExclusive("replay", "feeder"),
+		checkExclusive("replay", "profile"),
+		checkExclusive("replay", "coordinator"),
+		checkExclusive("har", "replay"),
+		checkExclusive("har", "target"),
+		checkExclusive("har", "url"),
+		checkExclusive("har", "feeder"),
+		checkExclusive("har", "profile"),
+		checkExclusive("har", "coordinator"),
+		checkExclusive("journey", "target"),
+		checkExclusive("journey", "url"),
+		checkExclusive("journey", "replay"),
+		checkExclusive("journey", "har"),
+		checkNonNegative("vus"),
+		checkExclusive("vus", "rate"),
+		checkExclusive("vus", "max-in-flight"),
+		checkExclusive("vus", "arrivals"),
+		checkExclusive("vus", "on-full"),
+		checkExclusive("vus", "replay"),
+		checkExclusive("vus", "har"),
+		checkExclusive("vus", "coordinator"),
+	),
+	RunE: func(cmd *cobra.Command, args []string) error {
 		localPort, _ := cmd.Flags().GetInt("health-port")
 		url, _ := cmd.Flags().GetString("url")
 		port, _ := cmd.Flags().GetInt("port")
 		rate, _ := cmd.Flags().GetInt("rate")
 		fail, _ := cmd.Flags().GetInt("fail")
 		failHealth, _ := cmd.Flags().GetInt("health-fail")
-		urlString := url + ":" + strconv.Itoa(port) + "/"
 		datadog, _ := cmd.Flags().GetBool("datadog")
-
+		reportInterval, _ := cmd.Flags().GetDuration("report-interval")
+		warmup, _ := cmd.Flags().GetDuration("warmup")
+		method, _ := cmd.Flags().GetString("method")
+		host, _ := cmd.Flags().GetString("host")
+		headers, _ := cmd.Flags().GetStringArray("header")
+		body, _ := cmd.Flags().GetString("body")
+		bodyFile, _ := cmd.Flags().GetString("body-file")
+		bodyTemplate, _ := cmd.Flags().GetString("body-template")
+		authSpec, _ := cmd.Flags().GetString("auth")
+		feederPath, _ := cmd.Flags().GetString("feeder")
+		feederMode, _ := cmd.Flags().GetString("feeder-mode")
+		profileSpec, _ := cmd.Flags().GetString("profile")
+		maxInFlight, _ := cmd.Flags().GetInt("max-in-flight")
+		arrivals, _ := cmd.Flags().GetString("arrivals")
+		onFull, _ := cmd.Flags().GetString("on-full")
+		outPath, _ := cmd.Flags().GetString("out")
+		outDetail, _ := cmd.Flags().GetString("out-detail")
+		junitPath, _ := cmd.Flags().GetString("junit")
+		duration, _ := cmd.Flags().GetDuration("duration")
+		requests, _ := cmd.Flags().GetInt64("requests")
+		thresholdExprs, _ := cmd.Flags().GetStringArray("threshold")
+		expectStatus, _ := cmd.Flags().GetString("expect-status")
+		expectBody, _ := cmd.Flags().GetString("expect-body")
+		expectBodyRegex, _ := cmd.Flags().GetString("expect-body-regex")
+		expectJSON, _ := cmd.Flags().GetStringArray("expect-json")
+		expectHeaders, _ := cmd.Flags().GetStringArray("expect-header")
+		maxLatency, _ := cmd.Flags().GetDuration("max-latency")
+		logFailures, _ := cmd.Flags().GetInt("log-failures")
+		maxAttempts, _ := cmd.Flags().GetInt("max-attempts")
+		retryOn, _ := cmd.Flags().GetString("retry-on")
+		retryBackoff, _ := cmd.Flags().GetDuration("retry-backoff")
+		retryMaxBackoff, _ := cmd.Flags().GetDuration("retry-max-backoff")
+		retryJitter, _ := cmd.Flags().GetString("retry-jitter")
+		retryBudget, _ := cmd.Flags().GetInt("retry-budget")
+		breaker, _ := cmd.Flags().GetBool("breaker")
+		breakerFailures, _ := cmd.Flags().GetInt("breaker-failures")
+		breakerFailureRate, _ := cmd.Flags().GetInt("breaker-failure-rate")
+		breakerWindow, _ := cmd.Flags().GetInt("breaker-window")
+		breakerOpen, _ := cmd.Flags().GetDuration("breaker-open")
+		breakerProbes, _ := cmd.Flags().GetInt("breaker-probes")
+		breakerOn, _ := cmd.Flags().GetString("breaker-on")
+		disableKeepAlives, _ := cmd.Flags().GetBool("disable-keep-alives")
+		maxIdleConnsPerHost, _ := cmd.Flags().GetInt("max-idle-conns-per-host")
+		maxConnsPerHost, _ := cmd.Flags().GetInt("max-conns-per-host")
+		reconnectEvery, _ := cmd.Flags().GetInt64("reconnect-every")
+		dnsRefresh, _ := cmd.Flags().GetDuration("dns-refresh")
+		preferIP, _ := cmd.Flags().GetString("prefer-ip")
+		coordinatorURL, _ := cmd.Flags().GetString("coordinator")
+		replayPath, _ := cmd.Flags().GetString("replay")
+		replaySpeed, _ := cmd.Flags().GetFloat64("replay-speed")
+		harPath, _ := cmd.Flags().GetString("har")
+		harHosts, _ := cmd.Flags().GetStringArray("har-host")
+		harDomains, _ := cmd.Flags().GetStringArray("har-domain")
+		controlWrites, _ := cmd.Flags().GetBool("control")
+		controlTokenRef, _ := cmd.Flags().GetString("control-token")
+		journeyPath, _ := cmd.Flags().GetString("journey")
+		vus, _ := cmd.Flags().GetInt("vus")
+		thinkSpec, _ := cmd.Flags().GetString("think")
+		if replaySpeed < 0 || math.IsNaN(replaySpeed) || math.IsInf(replaySpeed, 0) {
+			return fmt.Errorf("invalid value %v for --replay-speed: must be a non-negative number", replaySpeed)
+		}
+		header, err := parseHeaders(headers)
+		if err != nil {
+			return err
+		}
+		if strings.ContainsAny(host, " /") {
+			return fmt.Errorf("invalid --host %q: expected a host name, ex example.com or example.com:8080", host)
+		}
+		if host != "" {
+			if _, ok := header["Host"]; !ok {
+				header["Host"] = host
+			}
+		}
 		server := &Server{
-			port: localPort,
-			name: "Worker",
+			port:    localPort,
+			name:    "Worker",
 			datadog: datadog,
+			limits:  defaultLimits(),
+			stop:    make(chan struct{}),
 		}
 
 		// instantiate server
 		server.logger = server.NewLogger()
 		server.router = server.NewRouter()
+
+		jsonChecks, err := parseJSONChecks(expectJSON)
+		if err != nil {
+			return err
+		}
+		targets := getTargets(cmd.Flags(), "target")
+		// with a coordinator, the plan sets the targets, rate, profile and limits
+		var plan *Plan
+		startRate, scale := float64(rate), 1.0
+		if vus > 0 {
+			// load profiles ramp the number of users instead of the rate
+			startRate = float64(vus)
+		}
+		begin := time.Now()
+		if coordinatorURL != "" {
+			hostname, _ := os.Hostname()
+			name := fmt.Sprintf("%v:%d", hostname, localPort)
+			server.logger.Printf("Joining coordinator %v as %v", coordinatorURL, name)
+			if plan, err = joinCoordinator(context.Background(), coordinatorURL, name); err != nil {
+				return err
+			}
+			server.logger.Printf("Joined as %v of %d workers, starting at %v", plan.Worker, plan.Workers, plan.Start.Format(time.RFC3339Nano))
+			targets = plan.Targets
+			startRate, scale = plan.Rate, plan.Scale
+			profileSpec, duration, requests = plan.Profile, plan.Duration, plan.Requests
+			begin = plan.Start
+		}
+		if len(targets) == 0 {
+			legacy, err := legacyURL(url, port, cmd.Flags().Changed("port"))
+			if err != nil {
+				return err
+			}
+			target, err := parseTarget(legacy)
+			if err != nil {
+				return err
+			}
+			targets = []*Target{target}
+		}
+		defaults := &Target{
+			Method:       strings.ToUpper(method),
+			Header:       header,
+			Body:         body,
+			BodyFile:     bodyFile,
+			BodyTemplate: bodyTemplate,
+			Auth:         authSpec,
+			Check: Checks{
+				Status:    expectStatus,
+				Body:      expectBody,
+				BodyRegex: expectBodyRegex,
+				JSON:      jsonChecks,
+				Header:    expectHeaders,
+			},
+		}
+		if maxLatency > 0 {
+			defaults.Check.MaxLatency = maxLatency.String()
+		}
+		if defaults.auth, err = parseAuth(authSpec); err != nil {
+			return fmt.Errorf("invalid --auth: %v", err)
+		}
+		for _, target := range targets {
+			target.inherit(defaults)
+			if err := target.compile(); err != nil {
+				return err
+			}
+		}
+
+		thresholds, err := parseThresholds(thresholdExprs)
+		if err != nil {
+			return err
+		}
+
+		profile, err := parseProfile(profileSpec, startRate)
+		if err != nil {
+			return err
+		}
+		profile = scaleProfile(profile, scale)
+
+		var rp *replay
+		source := ""
+		if replayPath != "" {
+			source = "--replay"
+			if len(targets) != 1 {
+				return fmt.Errorf("--replay sends every request to a single --target or --url, got %d targets", len(targets))
+			}
+			recorded, err := loadRecording(replayPath)
+			if err != nil {
+				return err
+			}
+			if rp, err = newReplay(recorded, targets[0], defaults, replaySpeed); err != nil {
+				return err
+			}
+		}
+		if harPath != "" {
+			if len(harHosts) == 0 && len(harDomains) == 0 {
+				return fmt.Errorf("--har needs --har-host or --har-domain to select the hosts to load test")
+			}
+			entries, err := loadHAR(harPath)
+			if err != nil {
+				return err
+			}
+			hosts, err := parseHostRewrites(harHosts)
+			if err != nil {
+				return err
+			}
+			if rp, err = newHARReplay(entries, harOptions{hosts: hosts, domains: harDomains}, defaults, replaySpeed); err != nil {
+				return err
+			}
+			replayPath, source = harPath, "--har"
+		}
+
+		if controlTokenRef != "" && !controlWrites {
+			return fmt.Errorf("--control-token protects the endpoints enabled by --control, set --control")
+		}
+		controlToken := ""
+		if controlTokenRef != "" {
+			if controlToken, err = readSecret(controlTokenRef); err != nil {
+				return fmt.Errorf("invalid --control-token: %v", err)
+			}
+		}
+		if cmd.Flags().Changed("think") && vus == 0 {
+			return fmt.Errorf("--think is the think time of --vus users, set --vus")
+		}
+		think, err := parseThinkTime(thinkSpec)
+		if err != nil {
+			return err
+		}
+		var users *virtualUsers
+		if vus > 0 {
+			users = newVirtualUsers(vus)
+		}
+
+		var jrn *journey
+		if journeyPath != "" {
+			if jrn, err = loadJourney(journeyPath, defaults); err != nil {
+				return err
+			}
+			source = "--journey"
+		}
+
+		var feed *feeder
+		if feederPath != "" {
+			if feed, err = loadFeeder(feederPath, feederMode); err != nil {
+				return err
+			}
+		}
+
 		// Initialize DataDog tracing
 		if datadog {
 			tracer.Start(
@@ -78,32 +342,213 @@ var workerCmd = &cobra.Command{
 			defer profiler.Stop()
 		}
 		server.logger.Printf("Starting %v on port :%v", server.name, server.port)
+		if warmup > 0 {
+			server.logger.Printf("Requests during the %v warm-up are excluded from stats", warmup)
+		}
+		if rp != nil {
+			if replaySpeed > 0 {
+				server.logger.Printf("Replaying %d requests of %v against %v at %vx speed over %v", len(rp.requests), replayPath, strings.Join(rp.Names(), ", "), replaySpeed, rp.Duration())
+			} else {
+				server.logger.Printf("Replaying %d requests of %v against %v as fast as possible", len(rp.requests), replayPath, strings.Join(rp.Names(), ", "))
+			}
+		}
+		if users != nil {
+			server.logger.Printf("Running %d virtual users thinking %v between requests", vus, think)
+		}
+		if jrn != nil {
+			server.logger.Printf("Running journey %v of %d steps for every request", jrn.Name, len(jrn.Steps))
+		}
 		if datadog {
 			server.router.Handle("/", datadogTraceMiddleware(server.router, notFound(time.Now()), os.Getenv("DD_SERVICE")))
-			server.router.Handle("/healthz", datadogTraceMiddleware(server.router, healthz(failHealth), os.Getenv("DD_SERVICE")))
+			server.router.Handle("/healthz", datadogTraceMiddleware(server.router, healthz(failHealth, server.Healthy), os.Getenv("DD_SERVICE")))
 		} else {
 			server.router.Handle("/", notFound(time.Now()))
-			server.router.Handle("/healthz", healthz(failHealth))
+			server.router.Handle("/healthz", healthz(failHealth, server.Healthy))
 		}
 
-
 		// allow rate of `rate` requests per second and disallow initial burst
-		rateLimit := timerate.NewLimiter(timerate.Limit(rate), 1)
+		rateLimit := timerate.NewLimiter(timerate.Limit(startRate*scale), 1)
 
 		// instantiate client
 		client := newClient(rateLimit)
-		ctx := context.Background()
+		client.stats = newStats(time.Until(begin) + warmup)
+		client.logFailures = int64(logFailures)
+		transport := newTransport(connOptions{
+			disableKeepAlives: disableKeepAlives,
+			maxIdlePerHost:    maxIdleConnsPerHost,
+			maxPerHost:        maxConnsPerHost,
+			dnsRefresh:        dnsRefresh,
+			preferIP:          preferIP,
+		})
+		client.client.Transport = transport
+		client.reconnectEvery = reconnectEvery
+		if breaker {
+			on, err := parseOutcomes(breakerOn)
+			if err != nil {
+				return fmt.Errorf("invalid circuit breaker condition %v", err)
+			}
+			client.breakers = newBreakers(&breakerConfig{
+				failures:    breakerFailures,
+				failureRate: float64(breakerFailureRate),
+				window:      breakerWindow,
+				openFor:     breakerOpen,
+				probes:      breakerProbes,
+				on:          on,
+			}, server.logger)
+		}
+		if maxAttempts > 1 {
+			if client.retry, err = newRetryPolicy(maxAttempts, retryOn, retryBackoff, retryMaxBackoff, retryJitter, float64(retryBudget)); err != nil {
+				return err
+			}
+		}
+		if outPath != "" {
+			if client.results, err = openResults(outPath, outDetail, client.stats.start); err != nil {
+				return err
+			}
+		}
+		picker := newTargetPicker(targets)
+		pacer := newRateController(client.Ratelimiter)
+		pacer.poisson = arrivals == arrivalsPoisson
+		_, constant := profile.(constantProfile)
+		ctl := &control{
+			pacer:    pacer,
+			picker:   picker,
+			stats:    client.stats,
+			defaults: defaults,
+			profiled: !constant,
+			users:    users,
+			source:   source,
+			writable: controlWrites,
+			token:    controlToken,
+			logger:   server.logger,
+		}
+		for path, handler := range map[string]http.Handler{
+			"/breakers": breakerStates(client.breakers),
+			"/stats":    ctl.statsHandler(),
+			"/pause":    ctl.pause(),
+			"/resume":   ctl.resume(),
+			"/rate":     ctl.rate(),
+			"/targets":  ctl.targets(),
+		} {
+			if datadog {
+				handler = datadogTraceMiddleware(server.router, handler, os.Getenv("DD_SERVICE"))
+			}
+			server.router.Handle(path, handler)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
 
-		go func() {
-			for {
-				err := client.Ratelimiter.Wait(ctx)
-				if err == nil { // This is a blocking call. Honors the rate limit
-					client.Do(urlString, fail, server.logger)
+		if dnsRefresh > 0 {
+			go closeIdleEvery(ctx, transport, dnsRefresh)
+		}
+
+		if !constant {
+			go func() {
+				if !sleepUntil(ctx, begin) {
+					return
+				}
+				if users != nil {
+					runProfile(ctx, profile, users, server.logger)
+				} else {
+					runProfile(ctx, profile, pacer, server.logger)
 				}
+				server.Stop()
+			}()
+		}
+
+		d := &dispatcher{
+			client:       client,
+			pacer:        pacer,
+			picker:       picker,
+			replay:       rp,
+			journey:      jrn,
+			users:        users,
+			think:        think,
+			feed:         feed,
+			fail:         fail,
+			logger:       server.logger,
+			maxInFlight:  maxInFlight,
+			dropWhenFull: onFull == onFullDrop,
+			limit:        requests,
+		}
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			if !sleepUntil(ctx, begin) {
+				return
+			}
+			if plan != nil && plan.Idle {
+				server.logger.Println("No share of the coordinator's request limit, stopping")
+				server.Stop()
+				return
+			}
+			switch d.Run(ctx) {
+			case errFeederDone:
+				server.logger.Printf("Every row of %v was used, stopping", feederPath)
+				server.Stop()
+			case errRequestsDone:
+				server.logger.Printf("Sent %d requests, stopping", requests)
+				server.Stop()
+			case errReplayDone:
+				server.logger.Printf("Replayed every request of %v, stopping", replayPath)
+				server.Stop()
 			}
 		}()
+		if duration > 0 {
+			timer := time.AfterFunc(time.Until(begin)+duration, func() {
+				server.logger.Printf("Ran for %v, stopping", duration)
+				server.Stop()
+			})
+			defer timer.Stop()
+		}
 
+		if reportInterval > 0 {
+			ticker := time.NewTicker(reportInterval)
+			defer ticker.Stop()
+			go func() {
+				for range ticker.C {
+					client.stats.Report(server.logger)
+				}
+			}()
+		}
+
+		started := time.Now()
 		server.Serve()
+		// stop sending and wait for the requests in flight
+		cancel()
+		<-done
+		server.logger.Println("Final report:")
+		client.stats.Report(server.logger)
+		summaries := client.stats.Summaries()
+		if plan != nil {
+			if err := plan.Report(context.Background(), client.stats.Export()); err != nil {
+				server.logger.Printf("Failed to send results to coordinator: %v", err)
+			} else {
+				server.logger.Printf("Results sent to coordinator %v", coordinatorURL)
+			}
+		}
+		results, failed := checkThresholds(thresholds, totalSummary(summaries), server.logger)
+		if client.results != nil {
+			if err := client.results.Close(summaries); err != nil {
+				return err
+			}
+			server.logger.Printf("Results written to %v", outPath)
+		}
+		if junitPath != "" {
+			report := newJUnitReport(summaries, started, time.Since(started))
+			for _, tc := range results {
+				report.add(tc)
+			}
+			if err := report.Write(junitPath); err != nil {
+				return err
+			}
+			server.logger.Printf("JUnit report written to %v", junitPath)
+		}
+		if failed > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d of %d thresholds failed", failed, len(thresholds))
+		}
+		return nil
 	},
 }
 
@@ -111,10 +556,69 @@ func init() {
 	rootCmd.AddCommand(workerCmd)
 
 	// Define flags
-	workerCmd.Flags().StringP("url", "u", "http://localhost", "target URL")
+	workerCmd.Flags().StringP("url", "u", "http://localhost", "target URL, may include a port, path and query")
+	workerCmd.Flags().VarP(&targetsValue{}, "target", "t", "target `URL [WEIGHT]` to send requests to, can be repeated, overrides --url and --port")
+	workerCmd.Flags().String("host", "", "Host header of every request, ex to test virtual host ingress rules, a Host --header takes precedence")
+	workerCmd.Flags().StringP("method", "X", http.MethodGet, "HTTP method of requests")
+	workerCmd.Flags().StringArrayP("header", "H", nil, "request header `Key: Value`, values are Go templates, can be repeated")
+	workerCmd.Flags().String("body", "", "literal request body")
+	workerCmd.Flags().String("body-file", "", "file to send as the request body")
+	workerCmd.Flags().String("body-template", "", "Go template rendered as the request body, @path reads the template from a file")
+	workerCmd.Flags().String("auth", "", "authenticate requests with basic:username=USER,password=SECRET, bearer:token=SECRET or oauth2:token-url=URL,client-id=ID,client-secret=SECRET[,scopes=SCOPES], secrets are read from env:NAME or file:PATH")
+	workerCmd.Flags().String("expect-status", "", "check responses have one of these status codes or ranges, ex 200-299,304")
+	workerCmd.Flags().String("expect-body", "", "check response bodies contain this text")
+	workerCmd.Flags().String("expect-body-regex", "", "check response bodies match this regular expression")
+	workerCmd.Flags().StringArray("expect-json", nil, "check the JSON response body has a `PATH=VALUE`, ex data.items.0.id=42, can be repeated")
+	workerCmd.Flags().StringArray("expect-header", nil, "check responses have a header `NAME`, can be repeated")
+	workerCmd.Flags().Duration("max-latency", 0, "check responses arrive within this duration, 0 = no limit")
+	workerCmd.Flags().Int("log-failures", 5, "log the body of the first N responses that fail their checks")
+	workerCmd.Flags().Int("max-attempts", 1, "attempts at each request, retrying the failures matching --retry-on, 1 = no retries")
+	workerCmd.Flags().String("retry-on", "429,502-504,connection_refused,connection_reset,eof,timeout", "status codes, ranges and error classes to retry")
+	workerCmd.Flags().Duration("retry-backoff", 100*time.Millisecond, "delay before the first retry, doubling with every attempt")
+	workerCmd.Flags().Duration("retry-max-backoff", 5*time.Second, "maximum delay between attempts, including delays from Retry-After headers")
+	workerCmd.Flags().String("retry-jitter", jitterFull, "randomization of retry delays, none, full (0 to the delay) or equal (half the delay plus up to half)")
+	workerCmd.Flags().Int("retry-budget", 0, "maximum retries as a % of requests, ex 10 = at most 10% extra requests, 0 = no limit")
+	workerCmd.Flags().Bool("breaker", false, "stop sending requests to a target while its circuit breaker is open, states are served on the health port at /breakers")
+	workerCmd.Flags().String("breaker-on", "500-599,connection_refused,connection_reset,eof,timeout", "status codes, ranges and error classes counted as failures by circuit breakers")
+	workerCmd.Flags().Int("breaker-failures", 5, "consecutive failures opening a circuit breaker, 0 = disabled")
+	workerCmd.Flags().Int("breaker-failure-rate", 50, "% of failures in the last --breaker-window requests opening a circuit breaker, 0 = disabled")
+	workerCmd.Flags().Int("breaker-window", 20, "number of requests the failure rate of a circuit breaker is computed over")
+	workerCmd.Flags().Duration("breaker-open", 10*time.Second, "how long an open circuit breaker rejects requests before letting probes through")
+	workerCmd.Flags().Int("breaker-probes", 1, "requests let through by a half-open circuit breaker, it closes if they all succeed")
+	workerCmd.Flags().Bool("disable-keep-alives", false, "open a new connection for every request")
+	workerCmd.Flags().Int("max-idle-conns-per-host", http.DefaultMaxIdleConnsPerHost, "maximum idle (keep-alive) connections kept per host")
+	workerCmd.Flags().Int("max-conns-per-host", 0, "maximum connections per host, requests wait for a free one, 0 = no limit")
+	workerCmd.Flags().Int64("reconnect-every", 0, "close the connection of every Nth request so the next one opens a new connection, 0 = never")
+	workerCmd.Flags().Duration("dns-refresh", 0, "resolve hosts again after this long and close idle connections, new connections rotate over the host's addresses, 0 = resolve for every new connection")
+	workerCmd.Flags().String("prefer-ip", ipAny, "IP version to connect with first, any, ipv4 or ipv6")
+	workerCmd.Flags().String("replay", "", "replay the requests of a server's --record NDJSON file against the target instead of sending requests at --rate")
+	workerCmd.Flags().Float64("replay-speed", 1, "pace of --replay and --har relative to the recording, ex 2 = twice as fast, 0 = as fast as possible")
+	workerCmd.Flags().String("har", "", "replay the requests of a HAR file, as exported by browsers, at their URLs instead of sending requests at --rate")
+	workerCmd.Flags().StringArray("har-host", nil, "`OLD=NEW` sends the --har requests for host OLD to NEW, a host or a URL like http://localhost:8080, can be repeated")
+	workerCmd.Flags().StringArray("har-domain", nil, "only replay the --har requests for this domain or its subdomains, can be repeated, only the --har-host hosts are replayed if unset")
+	workerCmd.Flags().String("journey", "", "YAML or JSON file of steps run in order, with a cookie jar and extracted values, by a new virtual user for every request instead of the targets")
+	workerCmd.Flags().String("feeder", "", "CSV (with a header row), NDJSON or JSON array file whose rows are exposed to templates, ex {{ .user_id }}")
+	workerCmd.Flags().String("feeder-mode", feederSequential, "how feeder rows are used: sequential, random or once (stop after the last row)")
+	workerCmd.Flags().String("profile", "constant", "load profile, one of constant, ramp:DURATION@RATE,..., step:DURATION@RATE,..., spike:peak=RATE,length=DURATION,every=DURATION, sine:min=RATE,max=RATE,period=DURATION or replay:FILE.csv")
+	workerCmd.Flags().Int("vus", 0, "run this many virtual users, each sending a request (or running the --journey) and thinking before the next one, instead of sending requests at --rate, --profile ramps the number of users")
+	workerCmd.Flags().String("think", "", "think time of --vus users between requests, DURATION, uniform:min=DURATION,max=DURATION or exponential:mean=DURATION")
+	workerCmd.Flags().Int("max-in-flight", 0, "send requests concurrently on schedule (open loop) with at most this many in flight, 0 = one at a time")
+	workerCmd.Flags().String("arrivals", arrivalsConstant, "request arrivals, constant or poisson (exponentially distributed intervals averaging --rate)")
+	workerCmd.Flags().String("on-full", onFullDrop, "what to do with a request when --max-in-flight requests are in flight, drop or delay it")
+	workerCmd.Flags().Duration("report-interval", 10*time.Second, "interval between per-target stats reports, 0 = only on shutdown")
+	workerCmd.Flags().Duration("duration", 0, "stop the worker after this long, 0 = run until interrupted")
+	workerCmd.Flags().Int64("requests", 0, "stop the worker after sending this many requests, 0 = no limit")
+	workerCmd.Flags().StringArray("threshold", nil, "`METRIC<VALUE` checked against the total when the worker stops, exits non-zero if it fails, ex p99<300ms, error_rate<1%, rps>=95, can be repeated")
+	workerCmd.Flags().String("out", "", "write results to a .json or .csv file when the worker stops")
+	workerCmd.Flags().String("out-detail", outSummary, "results written to --out, summary (per target) or requests (a record per request)")
+	workerCmd.Flags().String("junit", "", "write a JUnit XML summary with a test case per target to this file when the worker stops")
+	workerCmd.Flags().String("coordinator", "", "`URL` of a coordinator to register with, its plan replaces the targets, rate, profile, duration and requests flags")
+	workerCmd.Flags().Duration("warmup", 0, "warm-up period after startup whose requests are excluded from stats")
 	workerCmd.Flags().IntP("health-port", "P", 8081, "worker healthcheck Port")
-	workerCmd.Flags().IntP("rate", "r", 1, "rate of requests per second")
-	workerCmd.Flags().IntP("port", "p", 8080, "target port")
+	workerCmd.Flags().Bool("control", false, "let the control API on the health port pause and resume the worker and change its rate and targets")
+	workerCmd.Flags().String("control-token", "", "bearer token required to pause, resume or change the worker through the control API, read from env:NAME or file:PATH")
+	workerCmd.Flags().IntP("rate", "r", 1, "rate of requests per second, the starting rate of ramp profiles and base rate of spike profiles, 0 = paused")
+	workerCmd.Flags().IntP("port", "p", 8080, "target port, unless --url has one, defaults to 443 for https URLs")
 	workerCmd.Flags().IntP("fail", "f", 0, "% of requests to fail, ex 10 = 10%")
 	workerCmd.Flags().IntP("health-fail", "F", 0, "% of requests to /healthz to fail, ex 10 = 10%")
 }
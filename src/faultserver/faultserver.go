@@ -0,0 +1,276 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package faultserver provides an embeddable HTTP server that injects
+// configurable faults, for use in integration tests of HTTP clients.
+//
+//	srv := faultserver.New(faultserver.Faults{FailPercent: 50})
+//	err := srv.Start(ctx)
+//	resp, err := http.Get(srv.URL())
+//	...
+//	srv.SetFaults(faultserver.Faults{Delay: 2 * time.Second})
+//
+// Tests can use faultservertest.NewServer, which closes the server when the test completes.
+package faultserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Faults controls how the server misbehaves, the zero value always succeeds
+	Faults struct {
+		// Delay is added before every response to /
+		Delay time.Duration
+		// FailPercent of requests to / respond with FailStatus, ex 10 = 10%
+		FailPercent int
+		// FailStatus is the status code of failed requests, defaults to 500
+		FailStatus int
+		// DropPercent of requests to / have their connection closed without a response
+		DropPercent int
+		// HealthFailPercent of requests to /healthz respond with 503
+		HealthFailPercent int
+	}
+	// Server is a fault-injecting HTTP server, create one with New
+	Server struct {
+		// Addr to listen on, defaults to a random port on 127.0.0.1
+		Addr string
+		// ErrorLog is passed on to the underlying http.Server
+		ErrorLog *log.Logger
+
+		mu       sync.RWMutex
+		faults   Faults
+		healthy  int32
+		listener net.Listener
+		server   *http.Server
+		done     chan struct{}
+		err      error
+	}
+)
+
+// New returns a server that injects the given faults, call Start to serve
+func New(faults Faults) *Server {
+	return &Server{faults: faults}
+}
+
+// Faults returns the faults currently being injected
+func (s *Server) Faults() Faults {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.faults
+}
+
+// SetFaults changes the injected faults, it is safe to call while serving
+func (s *Server) SetFaults(faults Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = faults
+}
+
+// Healthy reports whether /healthz is passing
+func (s *Server) Healthy() bool {
+	return atomic.LoadInt32(&s.healthy) == 1
+}
+
+// SetHealthy marks the server as ready or not, /healthz fails while it is unhealthy
+func (s *Server) SetHealthy(healthy bool) {
+	if healthy {
+		atomic.StoreInt32(&s.healthy, 1)
+	} else {
+		atomic.StoreInt32(&s.healthy, 0)
+	}
+}
+
+// Handler returns the routes served by the server
+func (s *Server) Handler() http.Handler {
+	router := http.NewServeMux()
+	router.Handle("/", Index(s.Faults))
+	router.Handle("/healthz", Healthz(s.Faults, s.Healthy))
+	return router
+}
+
+// Start listens on Addr and serves in the background until ctx is done or the
+// server is closed. It returns an error if the listener cannot be created.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server != nil {
+		return errors.New("faultserver: server already started")
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = "127.0.0.1:0"
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		ErrorLog:          s.ErrorLog,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	s.done = make(chan struct{})
+
+	go func() {
+		err := s.server.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			s.err = err
+		}
+		close(s.done)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}()
+
+	s.SetHealthy(true)
+	return nil
+}
+
+// URL returns the base URL of a started server, ex http://127.0.0.1:41235
+func (s *Server) URL() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.listener == nil {
+		return ""
+	}
+	return "http://" + s.listener.Addr().String()
+}
+
+// Shutdown gracefully stops the server, waiting for active requests until ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.RLock()
+	server := s.server
+	s.mu.RUnlock()
+	if server == nil {
+		return nil
+	}
+	s.SetHealthy(false)
+	server.SetKeepAlivesEnabled(false)
+	return server.Shutdown(ctx)
+}
+
+// Close immediately stops the server and closes all connections
+func (s *Server) Close() error {
+	s.mu.RLock()
+	server := s.server
+	s.mu.RUnlock()
+	if server == nil {
+		return nil
+	}
+	s.SetHealthy(false)
+	return server.Close()
+}
+
+// Wait blocks until the server stops and returns the error that stopped it, if any
+func (s *Server) Wait() error {
+	s.mu.RLock()
+	done := s.done
+	s.mu.RUnlock()
+	if done == nil {
+		return nil
+	}
+	<-done
+	return s.err
+}
+
+// Index serves / with the faults returned by faults, other paths get a 404
+func Index(faults func() Faults) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if r.URL.Path != "/" {
+			w.Header().Set("X-Response-Code", "404")
+			w.Header().Set("X-Request-Duration", time.Since(start).String())
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("404 - Not Found"))
+			return
+		}
+		f := faults()
+		time.Sleep(f.Delay)
+		if rand.Intn(100) < f.DropPercent {
+			drop(w)
+			return
+		}
+		if rand.Intn(100) < f.FailPercent {
+			status := f.FailStatus
+			if status == 0 {
+				status = http.StatusInternalServerError
+			}
+			w.Header().Set("X-Response-Code", strconv.Itoa(status))
+			w.Header().Set("X-Request-Duration", time.Since(start).String())
+			w.WriteHeader(status)
+			w.Write([]byte(strconv.Itoa(status) + " - " + http.StatusText(status)))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Response-Code", "200")
+		w.Header().Set("X-Request-Duration", time.Since(start).String())
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("200 - OK"))
+	})
+}
+
+// Healthz serves /healthz, failing while healthy reports false or for HealthFailPercent of requests
+func Healthz(faults func() Faults, healthy func() bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		if healthy() && rand.Intn(100) >= faults().HealthFailPercent {
+			w.Header().Set("X-Response-Code", "204")
+			w.Header().Set("X-Request-Duration", time.Since(start).String())
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.Header().Set("X-Response-Code", "503")
+			w.Header().Set("X-Request-Duration", time.Since(start).String())
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+}
+
+// closes the underlying connection so the client sees a transport error
+func drop(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		panic(http.ErrAbortHandler)
+	}
+	conn.Close()
+}
@@ -0,0 +1,153 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package faultserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		faults Faults
+		want   int
+	}{
+		{name: "ok", path: "/", want: http.StatusOK},
+		{name: "not found", path: "/missing", want: http.StatusNotFound},
+		{name: "fail", path: "/", faults: Faults{FailPercent: 100}, want: http.StatusInternalServerError},
+		{name: "fail with status", path: "/", faults: Faults{FailPercent: 100, FailStatus: http.StatusTooManyRequests}, want: http.StatusTooManyRequests},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Index(func() Faults { return tt.faults }).ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.want {
+				t.Errorf("Index() status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	tests := []struct {
+		name    string
+		faults  Faults
+		healthy bool
+		want    int
+	}{
+		{name: "healthy", healthy: true, want: http.StatusNoContent},
+		{name: "unhealthy", healthy: false, want: http.StatusServiceUnavailable},
+		{name: "fail", faults: Faults{HealthFailPercent: 100}, healthy: true, want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Healthz(func() Faults { return tt.faults }, func() bool { return tt.healthy }).ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+			if w.Code != tt.want {
+				t.Errorf("Healthz() status = %v, want %v", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer(t *testing.T) {
+	srv := startServer(t, Faults{})
+	other := startServer(t, Faults{FailPercent: 100})
+	get := func(url string) (int, error) {
+		resp, err := http.Get(url)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+
+	if code, err := get(srv.URL()); err != nil || code != http.StatusOK {
+		t.Fatalf("GET / = %v, %v, want 200", code, err)
+	}
+	if code, err := get(other.URL()); err != nil || code != http.StatusInternalServerError {
+		t.Fatalf("GET / = %v, %v, want 500", code, err)
+	}
+
+	srv.SetFaults(Faults{FailPercent: 100, FailStatus: http.StatusBadGateway})
+	if code, err := get(srv.URL()); err != nil || code != http.StatusBadGateway {
+		t.Errorf("GET / after SetFaults = %v, %v, want 502", code, err)
+	}
+
+	srv.SetFaults(Faults{DropPercent: 100})
+	if _, err := get(srv.URL()); err == nil {
+		t.Errorf("GET / with DropPercent = nil error, want a transport error")
+	}
+
+	srv.SetHealthy(false)
+	if code, err := get(srv.URL() + "/healthz"); err != nil || code != http.StatusServiceUnavailable {
+		t.Errorf("GET /healthz on unhealthy server = %v, %v, want 503", code, err)
+	}
+	if code, err := get(other.URL() + "/healthz"); err != nil || code != http.StatusNoContent {
+		t.Errorf("GET /healthz on other server = %v, %v, want 204", code, err)
+	}
+}
+
+func TestServer_Start(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := New(Faults{})
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("Server.Start() error = %v", err)
+	}
+	if err := srv.Start(ctx); err == nil {
+		t.Errorf("Server.Start() twice error = nil, want error")
+	}
+	busy := &Server{Addr: srv.URL()[len("http://"):]}
+	if err := busy.Start(ctx); err == nil {
+		t.Errorf("Server.Start() on a used port error = nil, want error")
+	}
+
+	cancel()
+	waited := make(chan error)
+	go func() { waited <- srv.Wait() }()
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Errorf("Server.Wait() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop after its context was cancelled")
+	}
+	if srv.Healthy() {
+		t.Errorf("Server.Healthy() after stop = true, want false")
+	}
+}
+
+func startServer(t *testing.T, faults Faults) *Server {
+	t.Helper()
+	s := New(faults)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
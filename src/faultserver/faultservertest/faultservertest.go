@@ -0,0 +1,47 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+
+// Package faultservertest starts fault-injecting servers for tests, it is kept
+// apart from faultserver so the testing package is not linked into binaries.
+//
+//	srv := faultservertest.NewServer(t, faultserver.Faults{FailPercent: 50})
+//	resp, err := http.Get(srv.URL())
+package faultservertest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cam3ron2/example-app/src/faultserver"
+)
+
+// NewServer starts a server on a random port, the server is closed when
+// the test and all its subtests complete
+func NewServer(tb testing.TB, faults faultserver.Faults) *faultserver.Server {
+	tb.Helper()
+	s := faultserver.New(faults)
+	if err := s.Start(context.Background()); err != nil {
+		tb.Fatalf("faultservertest: unable to start server: %v", err)
+	}
+	tb.Cleanup(func() { s.Close() })
+	return s
+}
@@ -0,0 +1,50 @@
+/*
+Copyright © 2022 Cameron Larsen <cameron.larsen@nielseniq.com>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+package faultservertest
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cam3ron2/example-app/src/faultserver"
+)
+
+func TestNewServer(t *testing.T) {
+	var srv *faultserver.Server
+	t.Run("serve", func(t *testing.T) {
+		srv = NewServer(t, faultserver.Faults{FailPercent: 100, FailStatus: http.StatusTeapot})
+		resp, err := http.Get(srv.URL())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusTeapot {
+			t.Errorf("GET / = %v, want %v", resp.StatusCode, http.StatusTeapot)
+		}
+	})
+	if srv.Healthy() {
+		t.Errorf("Server.Healthy() after the test completed = true, want the server closed")
+	}
+	if err := srv.Wait(); err != nil {
+		t.Errorf("Server.Wait() = %v", err)
+	}
+}